@@ -0,0 +1,141 @@
+package ladonsqlmanager
+
+import (
+	"context"
+
+	"github.com/ory/ladon"
+	"github.com/pkg/errors"
+	"gorm.io/gorm"
+)
+
+// BulkResult is the outcome for a single policy of CreateMany or UpsertMany. Err is
+// nil when the policy was stored.
+type BulkResult struct {
+	ID  string
+	Err error
+	// Skipped lists the templates left out of the stored policy when
+	// Config.LenientTemplates is set
+	Skipped []*TemplateError
+}
+
+// BulkReport lists the outcome of every policy passed to CreateMany or UpsertMany,
+// in the order the policies were given
+type BulkReport []BulkResult
+
+// Failed returns the results of the policies that were not stored
+func (r BulkReport) Failed() BulkReport {
+	failed := make(BulkReport, 0)
+	for _, result := range r {
+		if result.Err != nil {
+			failed = append(failed, result)
+		}
+	}
+	return failed
+}
+
+// CreateMany inserts the policies in a single transaction, or in one transaction per
+// Config.BulkChunkSize policies. Entities shared between the policies are inserted
+// once per chunk. Each policy is stored in its own savepoint, so a failing policy is
+// only reported in the BulkReport and does not affect the others. The error is set
+// when a whole chunk failed, in which case the remaining chunks are not processed.
+func (s *SQLManager) CreateMany(ctx context.Context, policies []ladon.Policy) (report BulkReport, err error) {
+	ctx, op := s.startOperation(ctx, "CreateMany")
+	defer func() {
+		op.metrics.Rows = len(report) - len(report.Failed())
+		op.finish(err)
+	}()
+
+	return s.bulk(ctx, policies, func(prepared *preparedPolicy, tx *gorm.DB) error {
+		return s.insertPolicy(prepared, tx)
+	})
+}
+
+// UpsertMany is like CreateMany, but updates the policies that already exist the
+// same way Update does, and creates the missing ones
+func (s *SQLManager) UpsertMany(ctx context.Context, policies []ladon.Policy) (report BulkReport, err error) {
+	ctx, op := s.startOperation(ctx, "UpsertMany")
+	defer func() {
+		op.metrics.Rows = len(report) - len(report.Failed())
+		op.finish(err)
+	}()
+
+	return s.bulk(ctx, policies, func(prepared *preparedPolicy, tx *gorm.DB) error {
+		err := s.update(prepared, tx)
+		if errors.Is(err, ErrPolicyNotFound) {
+			return s.insertPolicy(prepared, tx)
+		}
+		return err
+	})
+}
+
+// bulk prepares all policies, then stores them chunk by chunk with store
+func (s *SQLManager) bulk(ctx context.Context, policies []ladon.Policy, store func(*preparedPolicy, *gorm.DB) error) (BulkReport, error) {
+	report := make(BulkReport, len(policies))
+	prepared := make([]*preparedPolicy, len(policies))
+
+	// Validation failures are reported without touching the database
+	for i, policy := range policies {
+		report[i].ID = policy.GetID()
+		prepared[i], report[i].Err = s.preparePolicy(policy)
+		if prepared[i] != nil {
+			report[i].Skipped = prepared[i].skipped
+		}
+	}
+
+	chunkSize := s.config.BulkChunkSize
+	if chunkSize <= 0 {
+		chunkSize = len(policies)
+	}
+
+	for begin := 0; begin < len(policies); begin += chunkSize {
+		end := begin + chunkSize
+		if end > len(policies) {
+			end = len(policies)
+		}
+
+		if err := s.bulkChunk(ctx, policies, prepared, report, begin, end, store); err != nil {
+			// Nothing from this chunk on was stored
+			for i := begin; i < len(policies); i++ {
+				if report[i].Err == nil {
+					report[i].Err = err
+				}
+			}
+			return report, err
+		}
+	}
+
+	return report, nil
+}
+
+// bulkChunk stores the policies in [begin, end) in one transaction. Config.QueryTimeout
+// applies to each chunk rather than to the whole call.
+func (s *SQLManager) bulkChunk(ctx context.Context, policies []ladon.Policy, prepared []*preparedPolicy, report BulkReport, begin, end int, store func(*preparedPolicy, *gorm.DB) error) error {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	err := s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		items := make([]policyItems, 0, 3*(end-begin))
+		for i := begin; i < end; i++ {
+			if report[i].Err == nil {
+				items = append(items, prepared[i].items...)
+			}
+		}
+		if err := s.insertEntities(items, tx); err != nil {
+			return err
+		}
+
+		for i := begin; i < end; i++ {
+			if report[i].Err != nil {
+				continue
+			}
+			report[i].Err = timeoutError(tx.Transaction(func(ptx *gorm.DB) error {
+				if err := store(prepared[i], ptx); err != nil {
+					return err
+				}
+				return s.publishChange(ptx, policies[i].GetID())
+			}))
+		}
+		return nil
+	})
+	return timeoutError(err)
+}
@@ -0,0 +1,187 @@
+package ladonsqlmanager
+
+import (
+	"context"
+	"testing"
+
+	"github.com/ladonsqlmanager/models"
+	"github.com/ory/ladon"
+)
+
+func TestBulkReportFailed(t *testing.T) {
+	report := BulkReport{
+		{ID: "1"},
+		{ID: "2", Err: ErrEmptyPolicyID},
+		{ID: "3"},
+	}
+
+	failed := report.Failed()
+	if len(failed) != 1 || failed[0].ID != "2" {
+		t.Errorf("Expected only policy 2 to be failed, got %v", failed)
+	}
+}
+
+func TestSQLiteCreateMany(t *testing.T) {
+	manager := newTestManager(t)
+	ctx := context.Background()
+
+	createTestPolicies(t, manager, &ladon.DefaultPolicy{
+		ID:          "existing",
+		Description: "already stored",
+		Subjects:    []string{"user"},
+		Effect:      ladon.AllowAccess,
+		Resources:   []string{"article:1"},
+		Actions:     []string{"read"},
+	})
+
+	report, err := manager.CreateMany(ctx, []ladon.Policy{
+		&ladon.DefaultPolicy{
+			ID:          "1",
+			Description: "users can read articles",
+			Subjects:    []string{"user"},
+			Effect:      ladon.AllowAccess,
+			Resources:   []string{"article:1", "article:2"},
+			Actions:     []string{"read"},
+		},
+		&ladon.DefaultPolicy{
+			ID:          "2",
+			Description: "missing effect",
+			Subjects:    []string{"user"},
+			Resources:   []string{"article:3"},
+			Actions:     []string{"read"},
+		},
+		&ladon.DefaultPolicy{
+			ID:          "existing",
+			Description: "duplicate of a stored policy",
+			Subjects:    []string{"user"},
+			Effect:      ladon.DenyAccess,
+			Resources:   []string{"article:4"},
+			Actions:     []string{"read"},
+		},
+		&ladon.DefaultPolicy{
+			ID:          "3",
+			Description: "admins can write articles",
+			Subjects:    []string{"admin", "user"},
+			Effect:      ladon.AllowAccess,
+			Resources:   []string{"article:2"},
+			Actions:     []string{"write"},
+		},
+		&ladon.DefaultPolicy{
+			ID:          "3",
+			Description: "duplicate within the batch",
+			Subjects:    []string{"admin"},
+			Effect:      ladon.AllowAccess,
+			Resources:   []string{"article:5"},
+			Actions:     []string{"write"},
+		},
+	})
+	if err != nil {
+		t.Fatalf("CreateMany failed: %v", err)
+	}
+
+	if len(report) != 5 {
+		t.Fatalf("Expected a result for each of the 5 policies, got %d", len(report))
+	}
+	for i, failed := range []bool{false, true, true, false, true} {
+		if (report[i].Err != nil) != failed {
+			t.Errorf("Expected result %d (%s) failed=%v, got %v", i, report[i].ID, failed, report[i].Err)
+		}
+	}
+	if len(report.Failed()) != 3 {
+		t.Errorf("Expected 3 failed policies, got %d", len(report.Failed()))
+	}
+
+	var count int64
+	manager.db.Model(&models.Policy{}).Count(&count)
+	if count != 3 {
+		t.Errorf("Expected 3 policies, got %d", count)
+	}
+
+	// The failed duplicate must not have touched the stored policy
+	existing, err := manager.Get(ctx, "existing")
+	if err != nil {
+		t.Fatalf("Get failed: %v", err)
+	}
+	if existing.GetDescription() != "already stored" {
+		t.Errorf("Expected stored policy to be unchanged, got '%s'", existing.GetDescription())
+	}
+
+	// Entities shared between the policies are stored once; entities of failed
+	// policies may remain but are harmless
+	manager.db.Model(&models.Subject{}).Count(&count)
+	if count != 2 {
+		t.Errorf("Expected 2 subject entities, got %d", count)
+	}
+
+	policy, err := manager.Get(ctx, "3")
+	if err != nil {
+		t.Fatalf("Get failed: %v", err)
+	}
+	if policy.GetDescription() != "admins can write articles" {
+		t.Errorf("Expected first policy with ID 3 to be stored, got '%s'", policy.GetDescription())
+	}
+	if len(policy.GetSubjects()) != 2 {
+		t.Errorf("Expected 2 subjects, got %v", policy.GetSubjects())
+	}
+}
+
+func TestSQLiteUpsertMany(t *testing.T) {
+	config := DefaultConfig()
+	config.BulkChunkSize = 1
+	manager := newTestManagerWithConfig(t, config)
+	ctx := context.Background()
+
+	createTestPolicies(t, manager, &ladon.DefaultPolicy{
+		ID:          "1",
+		Description: "users can read articles",
+		Subjects:    []string{"user"},
+		Effect:      ladon.AllowAccess,
+		Resources:   []string{"article:1"},
+		Actions:     []string{"read"},
+	})
+
+	report, err := manager.UpsertMany(ctx, []ladon.Policy{
+		&ladon.DefaultPolicy{
+			ID:          "1",
+			Description: "users can read and write articles",
+			Subjects:    []string{"user"},
+			Effect:      ladon.AllowAccess,
+			Resources:   []string{"article:1"},
+			Actions:     []string{"read", "write"},
+		},
+		&ladon.DefaultPolicy{
+			ID:          "2",
+			Description: "admins can delete articles",
+			Subjects:    []string{"admin"},
+			Effect:      ladon.AllowAccess,
+			Resources:   []string{"article:1"},
+			Actions:     []string{"delete"},
+		},
+		&ladon.DefaultPolicy{
+			ID:      "",
+			Effect:  ladon.AllowAccess,
+			Actions: []string{"read"},
+		},
+	})
+	if err != nil {
+		t.Fatalf("UpsertMany failed: %v", err)
+	}
+	if len(report.Failed()) != 1 || report.Failed()[0].ID != "" {
+		t.Errorf("Expected only the policy without ID to fail, got %v", report.Failed())
+	}
+
+	updated, err := manager.Get(ctx, "1")
+	if err != nil {
+		t.Fatalf("Get failed: %v", err)
+	}
+	if updated.GetDescription() != "users can read and write articles" {
+		t.Errorf("Expected policy 1 to be updated, got '%s'", updated.GetDescription())
+	}
+	if len(updated.GetActions()) != 2 {
+		t.Errorf("Expected 2 actions, got %v", updated.GetActions())
+	}
+
+	if _, err := manager.Get(ctx, "2"); err != nil {
+		t.Errorf("Expected policy 2 to be created, got %v", err)
+	}
+}
@@ -0,0 +1,272 @@
+package ladonsqlmanager
+
+import (
+	"container/list"
+	"context"
+	"sync"
+	"time"
+
+	"github.com/ory/ladon"
+)
+
+// CacheConfig holds configuration options for CachedManager
+type CacheConfig struct {
+	// TTL is how long cached candidate sets and policies are served
+	TTL time.Duration
+	// MaxEntries bounds the number of cached candidate sets and, separately, the
+	// number of cached policies. The least recently used entries are evicted first.
+	MaxEntries int
+}
+
+// DefaultCacheConfig returns a default cache configuration
+func DefaultCacheConfig() CacheConfig {
+	return CacheConfig{
+		TTL:        time.Minute,
+		MaxEntries: 10000,
+	}
+}
+
+// CachedManager is a read-through cache in front of SQLManager. It caches the
+// candidate sets of FindRequestCandidates per subject and policies by ID, and
+// implements ladon.Manager so it can replace the SQLManager in ladon.Ladon.
+//
+// Create, Update and Delete made through the CachedManager invalidate the cache.
+// Writes made directly through the SQLManager, e.g. CreateMany or Restore, or by
+// other processes are only picked up after the TTL, unless InvalidatePolicy or
+// InvalidateAll is called.
+type CachedManager struct {
+	manager *SQLManager
+	config  CacheConfig
+
+	mu         sync.Mutex
+	generation uint64
+	candidates *lruCache
+	policies   *lruCache
+}
+
+// NewCachedManager wraps manager with a cache
+func NewCachedManager(manager *SQLManager, config CacheConfig) *CachedManager {
+	return &CachedManager{
+		manager:    manager,
+		config:     config,
+		candidates: newLRUCache(config.MaxEntries),
+		policies:   newLRUCache(config.MaxEntries),
+	}
+}
+
+// Create inserts a new policy and invalidates the cache
+func (c *CachedManager) Create(ctx context.Context, policy ladon.Policy) error {
+	defer c.InvalidatePolicy(policy.GetID())
+	return c.manager.Create(ctx, policy)
+}
+
+// Update updates a policy and invalidates the cache
+func (c *CachedManager) Update(ctx context.Context, policy ladon.Policy) error {
+	defer c.InvalidatePolicy(policy.GetID())
+	return c.manager.Update(ctx, policy)
+}
+
+// Delete removes a policy and invalidates the cache
+func (c *CachedManager) Delete(ctx context.Context, id string) error {
+	defer c.InvalidatePolicy(id)
+	return c.manager.Delete(ctx, id)
+}
+
+// Get retrieves a policy, from the cache if possible
+func (c *CachedManager) Get(ctx context.Context, id string) (ladon.Policy, error) {
+	now := time.Now()
+
+	c.mu.Lock()
+	if value, ok := c.policies.get(id, now); ok {
+		c.mu.Unlock()
+		return value.(ladon.Policy), nil
+	}
+	generation := c.generation
+	c.mu.Unlock()
+
+	policy, err := c.manager.Get(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	c.mu.Lock()
+	if generation == c.generation {
+		c.policies.add(id, policy, now.Add(c.config.TTL))
+	}
+	c.mu.Unlock()
+	return policy, nil
+}
+
+// GetAll returns all policies. It is not cached.
+func (c *CachedManager) GetAll(ctx context.Context, limit, offset int64) (ladon.Policies, error) {
+	return c.manager.GetAll(ctx, limit, offset)
+}
+
+// FindRequestCandidates returns policies that potentially match a ladon.Request,
+// from the cache if possible
+func (c *CachedManager) FindRequestCandidates(ctx context.Context, r *ladon.Request) (ladon.Policies, error) {
+	key := c.candidatesKey(r)
+	now := time.Now()
+
+	c.mu.Lock()
+	if policies, ok := c.cachedCandidates(key, now); ok {
+		c.mu.Unlock()
+		return policies, nil
+	}
+	generation := c.generation
+	c.mu.Unlock()
+
+	policies, err := c.manager.FindRequestCandidates(ctx, r)
+	if err != nil {
+		return nil, err
+	}
+
+	c.mu.Lock()
+	// A write that happened while querying may not be part of the result
+	if generation == c.generation {
+		expires := now.Add(c.config.TTL)
+		ids := make([]string, len(policies))
+		for i, policy := range policies {
+			ids[i] = policy.GetID()
+			c.policies.add(policy.GetID(), policy, expires)
+		}
+		c.candidates.add(key, ids, expires)
+	}
+	c.mu.Unlock()
+	return policies, nil
+}
+
+// FindPoliciesForSubject returns policies that could match the subject. It is not cached.
+func (c *CachedManager) FindPoliciesForSubject(ctx context.Context, subject string) (ladon.Policies, error) {
+	return c.manager.FindPoliciesForSubject(ctx, subject)
+}
+
+// FindPoliciesForResource returns policies that could match the resource. It is not cached.
+func (c *CachedManager) FindPoliciesForResource(ctx context.Context, resource string) (ladon.Policies, error) {
+	return c.manager.FindPoliciesForResource(ctx, resource)
+}
+
+// FindPoliciesForAction returns policies that could match the action. It is not cached.
+func (c *CachedManager) FindPoliciesForAction(ctx context.Context, action string) (ladon.Policies, error) {
+	return c.manager.FindPoliciesForAction(ctx, action)
+}
+
+// InvalidatePolicy evicts a policy. All candidate sets are evicted as well, because
+// a created or changed policy may match any subject.
+func (c *CachedManager) InvalidatePolicy(id string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.generation++
+	c.policies.remove(id)
+	c.candidates.clear()
+}
+
+// InvalidateAll empties the cache
+func (c *CachedManager) InvalidateAll() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.generation++
+	c.policies.clear()
+	c.candidates.clear()
+}
+
+// candidatesKey returns the cache key of a request. Without StrictCandidates the
+// candidates only depend on the subject.
+func (c *CachedManager) candidatesKey(r *ladon.Request) string {
+	if !c.manager.config.StrictCandidates {
+		return r.Subject
+	}
+	return r.Subject + "\x00" + r.Action + "\x00" + r.Resource
+}
+
+// cachedCandidates resolves a cached candidate set. It is a miss when one of the
+// policies was evicted in the meantime. The caller must hold c.mu.
+func (c *CachedManager) cachedCandidates(key string, now time.Time) (ladon.Policies, bool) {
+	value, ok := c.candidates.get(key, now)
+	if !ok {
+		return nil, false
+	}
+
+	ids := value.([]string)
+	policies := make(ladon.Policies, 0, len(ids))
+	for _, id := range ids {
+		policy, ok := c.policies.get(id, now)
+		if !ok {
+			c.candidates.remove(key)
+			return nil, false
+		}
+		policies = append(policies, policy.(ladon.Policy))
+	}
+	return policies, true
+}
+
+// lruCache is a size bounded map with expiring entries. It is not safe for
+// concurrent use.
+type lruCache struct {
+	maxEntries int
+	order      *list.List
+	entries    map[string]*list.Element
+}
+
+type lruEntry struct {
+	key     string
+	value   interface{}
+	expires time.Time
+}
+
+// newLRUCache creates a cache holding at most maxEntries entries. Zero or less
+// means unbounded.
+func newLRUCache(maxEntries int) *lruCache {
+	return &lruCache{
+		maxEntries: maxEntries,
+		order:      list.New(),
+		entries:    make(map[string]*list.Element),
+	}
+}
+
+func (l *lruCache) get(key string, now time.Time) (interface{}, bool) {
+	element, ok := l.entries[key]
+	if !ok {
+		return nil, false
+	}
+	entry := element.Value.(*lruEntry)
+	if !now.Before(entry.expires) {
+		l.removeElement(element)
+		return nil, false
+	}
+	l.order.MoveToFront(element)
+	return entry.value, true
+}
+
+func (l *lruCache) add(key string, value interface{}, expires time.Time) {
+	if element, ok := l.entries[key]; ok {
+		element.Value = &lruEntry{key: key, value: value, expires: expires}
+		l.order.MoveToFront(element)
+		return
+	}
+
+	l.entries[key] = l.order.PushFront(&lruEntry{key: key, value: value, expires: expires})
+	if l.maxEntries > 0 && l.order.Len() > l.maxEntries {
+		l.removeElement(l.order.Back())
+	}
+}
+
+func (l *lruCache) remove(key string) {
+	if element, ok := l.entries[key]; ok {
+		l.removeElement(element)
+	}
+}
+
+func (l *lruCache) removeElement(element *list.Element) {
+	l.order.Remove(element)
+	delete(l.entries, element.Value.(*lruEntry).key)
+}
+
+func (l *lruCache) clear() {
+	l.order.Init()
+	l.entries = make(map[string]*list.Element)
+}
+
+func (l *lruCache) len() int {
+	return l.order.Len()
+}
@@ -0,0 +1,200 @@
+package ladonsqlmanager
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/ory/ladon"
+)
+
+var _ ladon.Manager = (*CachedManager)(nil)
+
+func TestLRUCache(t *testing.T) {
+	cache := newLRUCache(2)
+	now := time.Now()
+	expires := now.Add(time.Minute)
+
+	cache.add("a", 1, expires)
+	cache.add("b", 2, expires)
+	cache.get("a", now)
+	cache.add("c", 3, expires)
+
+	if _, ok := cache.get("b", now); ok {
+		t.Error("Expected least recently used entry to be evicted")
+	}
+	if value, ok := cache.get("a", now); !ok || value != 1 {
+		t.Errorf("Expected a=1, got %v", value)
+	}
+	if cache.len() != 2 {
+		t.Errorf("Expected 2 entries, got %d", cache.len())
+	}
+
+	if _, ok := cache.get("c", expires); ok {
+		t.Error("Expected expired entry to be a miss")
+	}
+	if cache.len() != 1 {
+		t.Errorf("Expected expired entry to be removed, got %d entries", cache.len())
+	}
+}
+
+func TestSQLiteCachedManager(t *testing.T) {
+	metrics := NewInMemoryMetrics()
+	config := DefaultConfig()
+	config.Metrics = metrics
+	cached := NewCachedManager(newTestManagerWithConfig(t, config), DefaultCacheConfig())
+	ctx := context.Background()
+
+	if err := cached.Create(ctx, &ladon.DefaultPolicy{
+		ID:          "1",
+		Description: "users can read articles",
+		Subjects:    []string{"user"},
+		Effect:      ladon.AllowAccess,
+		Resources:   []string{"article:<.*>"},
+		Actions:     []string{"read"},
+	}); err != nil {
+		t.Fatalf("Create failed: %v", err)
+	}
+
+	calls := func(operation string) int64 {
+		return metrics.Snapshot()[operation].Calls
+	}
+
+	request := &ladon.Request{Subject: "user", Action: "read", Resource: "article:1"}
+	for i := 0; i < 3; i++ {
+		policies, err := cached.FindRequestCandidates(ctx, request)
+		if err != nil {
+			t.Fatalf("FindRequestCandidates failed: %v", err)
+		}
+		if len(policies) != 1 {
+			t.Errorf("Expected 1 candidate, got %d", len(policies))
+		}
+	}
+	if calls("FindRequestCandidates") != 1 {
+		t.Errorf("Expected 1 database lookup, got %d", calls("FindRequestCandidates"))
+	}
+
+	// Policies of cached candidate sets are served by Get as well
+	if _, err := cached.Get(ctx, "1"); err != nil {
+		t.Fatalf("Get failed: %v", err)
+	}
+	if calls("Get") != 0 {
+		t.Errorf("Expected Get to be served from the cache, got %d lookups", calls("Get"))
+	}
+
+	// A new policy invalidates the candidate sets
+	if err := cached.Create(ctx, &ladon.DefaultPolicy{
+		ID:          "2",
+		Description: "users can write articles",
+		Subjects:    []string{"<.*>"},
+		Effect:      ladon.AllowAccess,
+		Resources:   []string{"article:<.*>"},
+		Actions:     []string{"write"},
+	}); err != nil {
+		t.Fatalf("Create failed: %v", err)
+	}
+	policies, err := cached.FindRequestCandidates(ctx, request)
+	if err != nil {
+		t.Fatalf("FindRequestCandidates failed: %v", err)
+	}
+	if len(policies) != 2 {
+		t.Errorf("Expected 2 candidates after Create, got %d", len(policies))
+	}
+
+	// Updates replace the cached policy
+	if err := cached.Update(ctx, &ladon.DefaultPolicy{
+		ID:          "1",
+		Description: "users can read news",
+		Subjects:    []string{"user"},
+		Effect:      ladon.AllowAccess,
+		Resources:   []string{"news:<.*>"},
+		Actions:     []string{"read"},
+	}); err != nil {
+		t.Fatalf("Update failed: %v", err)
+	}
+	policy, err := cached.Get(ctx, "1")
+	if err != nil {
+		t.Fatalf("Get failed: %v", err)
+	}
+	if policy.GetDescription() != "users can read news" {
+		t.Errorf("Expected updated policy, got '%s'", policy.GetDescription())
+	}
+
+	// Deleted policies are no longer served
+	if err := cached.Delete(ctx, "1"); err != nil {
+		t.Fatalf("Delete failed: %v", err)
+	}
+	if _, err := cached.Get(ctx, "1"); err == nil {
+		t.Error("Expected deleted policy to be gone")
+	}
+	policies, err = cached.FindRequestCandidates(ctx, request)
+	if err != nil {
+		t.Fatalf("FindRequestCandidates failed: %v", err)
+	}
+	if len(policies) != 1 || policies[0].GetID() != "2" {
+		t.Errorf("Expected only policy 2 after Delete, got %v", policyIDs(policies))
+	}
+
+	// Works as the manager of a warden
+	warden := &ladon.Ladon{Manager: cached}
+	if err := warden.IsAllowed(ctx, &ladon.Request{Subject: "user", Action: "write", Resource: "article:1"}); err != nil {
+		t.Errorf("Expected access to be allowed, got %v", err)
+	}
+}
+
+func TestSQLiteCachedManagerTTL(t *testing.T) {
+	manager := newTestManager(t)
+	cacheConfig := DefaultCacheConfig()
+	cacheConfig.TTL = 20 * time.Millisecond
+	cached := NewCachedManager(manager, cacheConfig)
+	ctx := context.Background()
+
+	createTestPolicies(t, manager, &ladon.DefaultPolicy{
+		ID:          "1",
+		Description: "users can read articles",
+		Subjects:    []string{"user"},
+		Effect:      ladon.AllowAccess,
+		Resources:   []string{"article:1"},
+		Actions:     []string{"read"},
+	})
+
+	request := &ladon.Request{Subject: "user"}
+	if _, err := cached.FindRequestCandidates(ctx, request); err != nil {
+		t.Fatalf("FindRequestCandidates failed: %v", err)
+	}
+
+	// Writes that bypass the cache are only seen after the TTL
+	createTestPolicies(t, manager, &ladon.DefaultPolicy{
+		ID:          "2",
+		Description: "users can write articles",
+		Subjects:    []string{"user"},
+		Effect:      ladon.AllowAccess,
+		Resources:   []string{"article:1"},
+		Actions:     []string{"write"},
+	})
+	policies, _ := cached.FindRequestCandidates(ctx, request)
+	if len(policies) != 1 {
+		t.Errorf("Expected the stale candidate set before the TTL, got %d policies", len(policies))
+	}
+
+	time.Sleep(30 * time.Millisecond)
+	policies, _ = cached.FindRequestCandidates(ctx, request)
+	if len(policies) != 2 {
+		t.Errorf("Expected 2 policies after the TTL, got %d", len(policies))
+	}
+
+	// InvalidateAll drops everything at once
+	createTestPolicies(t, manager, &ladon.DefaultPolicy{
+		ID:          "3",
+		Description: "users can delete articles",
+		Subjects:    []string{"user"},
+		Effect:      ladon.AllowAccess,
+		Resources:   []string{"article:1"},
+		Actions:     []string{"delete"},
+	})
+	cached.InvalidateAll()
+	policies, _ = cached.FindRequestCandidates(ctx, request)
+	if len(policies) != 3 {
+		t.Errorf("Expected 3 policies after InvalidateAll, got %d", len(policies))
+	}
+}
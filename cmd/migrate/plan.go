@@ -0,0 +1,102 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+
+	"github.com/ladonsqlmanager"
+	"github.com/ladonsqlmanager/migrations"
+	"gorm.io/gorm"
+)
+
+// schemaAction returns the migrations call of a schema changing action, so it can be
+// run for real or as a dry run
+func schemaAction(db *gorm.DB, action string, version int64) (func(opts ...migrations.Option) error, bool) {
+	switch action {
+	case "migrate", "up":
+		return func(opts ...migrations.Option) error { return migrations.Migrate(db, opts...) }, true
+	case "down":
+		return func(opts ...migrations.Option) error { return migrations.Down(db, opts...) }, true
+	case "to":
+		return func(opts ...migrations.Option) error { return migrations.To(db, version, opts...) }, true
+	case "drop":
+		return func(opts ...migrations.Option) error { return migrations.DropTables(db, opts...) }, true
+	case "reset":
+		return func(opts ...migrations.Option) error { return migrations.ResetDatabase(db, opts...) }, true
+	default:
+		return nil, false
+	}
+}
+
+// isDestructive reports whether an action drops tables, columns or policies. Migrating
+// to a version is destructive when it reverts applied migrations.
+func isDestructive(db *gorm.DB, action string, version int64) (bool, error) {
+	switch action {
+	case "drop", "reset", "down", "purge":
+		return true, nil
+	case "to":
+		status, err := migrations.Status(db)
+		if err != nil {
+			return false, err
+		}
+		for _, s := range status {
+			if s.Applied && s.Version > version {
+				return true, nil
+			}
+		}
+	}
+	return false, nil
+}
+
+// printPlan prints the migrations, table and index changes and SQL statements of a dry run
+func printPlan(plan *migrations.Plan) {
+	fmt.Println("Migrations:")
+	if len(plan.Steps) == 0 {
+		fmt.Println("  none")
+	}
+	for _, step := range plan.Steps {
+		direction := "apply"
+		if step.Revert {
+			direction = "revert"
+		}
+		fmt.Printf("  %s %d %s\n", direction, step.Version, step.Name)
+	}
+
+	fmt.Println("Changes:")
+	changes := plan.Changes()
+	if len(changes) == 0 {
+		fmt.Println("  none")
+	}
+	for _, change := range changes {
+		fmt.Printf("  %s\n", change)
+	}
+
+	fmt.Println("SQL:")
+	for _, statement := range plan.Statements {
+		fmt.Printf("  %s;\n", statement)
+	}
+}
+
+// planImportLegacy prints the rows import-legacy would copy from legacyDB, or convert
+// in place when it is empty, and the plan of its schema changes
+func planImportLegacy(db *gorm.DB, legacyDB string) {
+	var source *gorm.DB
+	if legacyDB != "" {
+		var err error
+		if source, err = openDatabase(legacyDB); err != nil {
+			log.Fatalf("Failed to connect to the legacy database: %v", err)
+		}
+	}
+
+	plan := &migrations.Plan{}
+	manager := ladonsqlmanager.New(db, db.Dialector.Name())
+	report, err := manager.PlanImportLegacy(context.Background(), source, plan)
+	if err != nil {
+		log.Fatalf("Planning failed: %v", err)
+	}
+
+	fmt.Printf("Import %d policies, %d subjects, %d actions, %d resources and %d relations\n",
+		report.Policies, report.Subjects, report.Actions, report.Resources, report.Relations)
+	printPlan(plan)
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"os"
+
+	"github.com/ladonsqlmanager"
+)
+
+// policyFormat returns the format given by the -format flag, or the one matching the
+// extension of path
+func policyFormat(path, format string) ladonsqlmanager.Format {
+	if format != "" {
+		return ladonsqlmanager.Format(format)
+	}
+	if format, ok := ladonsqlmanager.FormatFromExtension(path); ok {
+		return format
+	}
+	return ladonsqlmanager.FormatJSON
+}
+
+// exportPolicies writes all policies to path, or to stdout when path is "-"
+func exportPolicies(manager *ladonsqlmanager.SQLManager, path string, format ladonsqlmanager.Format) (int, error) {
+	var out io.Writer = os.Stdout
+	if path != "-" {
+		file, err := os.Create(path)
+		if err != nil {
+			return 0, err
+		}
+		defer file.Close()
+		out = file
+	}
+	return manager.Export(context.Background(), out, format)
+}
+
+// importPolicies reads policies from path, or from stdin when path is "-", and
+// prints the result of every policy
+func importPolicies(manager *ladonsqlmanager.SQLManager, path string, format ladonsqlmanager.Format, mode ladonsqlmanager.ImportMode) (ladonsqlmanager.ImportReport, error) {
+	var in io.Reader = os.Stdin
+	if path != "-" {
+		file, err := os.Open(path)
+		if err != nil {
+			return nil, err
+		}
+		defer file.Close()
+		in = file
+	}
+
+	report, err := manager.Import(context.Background(), in, format, mode)
+	for _, result := range report {
+		status := "ok"
+		if result.Err != nil {
+			status = result.Err.Error()
+		}
+		fmt.Printf("%s\t%s\t%s\n", result.ID, result.Action, status)
+		for _, skipped := range result.Skipped {
+			log.Printf("Skipped %s", skipped)
+		}
+	}
+	return report, err
+}
+
+// driftExitCode is the exit status of a sync dry run that found changes
+const driftExitCode = 2
+
+// syncPolicies prints the plan reconciling the database with the policy files in dir
+// and applies it. A dry run exits with driftExitCode when the plan has changes.
+func syncPolicies(manager *ladonsqlmanager.SQLManager, dir string, prune, dryRun, confirmed bool) {
+	ctx := context.Background()
+	desired, err := manager.LoadPolicies(dir)
+	if err != nil {
+		log.Fatalf("Loading policies failed: %v", err)
+	}
+
+	plan, err := manager.PlanSync(ctx, desired, prune)
+	if err != nil {
+		log.Fatalf("Planning failed: %v", err)
+	}
+	if err := plan.Write(os.Stdout); err != nil {
+		log.Fatalf("Printing the plan failed: %v", err)
+	}
+
+	if !plan.HasChanges() {
+		return
+	}
+	if dryRun {
+		os.Exit(driftExitCode)
+	}
+	if plan.Count(ladonsqlmanager.SyncDelete) > 0 && !confirmed {
+		log.Fatal("The plan deletes policies. Run it again with -yes to apply it.")
+	}
+
+	if err := manager.ApplySync(ctx, plan); err != nil {
+		log.Fatalf("Sync failed: %v", err)
+	}
+	log.Printf("✅ Applied %d changes", len(plan.Changes))
+}
@@ -0,0 +1,108 @@
+package ladonsqlmanager
+
+import (
+	"encoding/json"
+	"sort"
+	"sync"
+
+	"github.com/ory/ladon"
+	"github.com/pkg/errors"
+)
+
+// ConditionRegistry manages the condition types SQLManager can decode. Types not
+// registered here are looked up in ladon.ConditionFactories.
+type ConditionRegistry struct {
+	mu        sync.RWMutex
+	factories map[string]func() ladon.Condition
+}
+
+// NewConditionRegistry creates a registry without custom condition types
+func NewConditionRegistry() *ConditionRegistry {
+	return &ConditionRegistry{
+		factories: make(map[string]func() ladon.Condition),
+	}
+}
+
+// RegisterCondition registers a factory for the condition type with the given name,
+// as returned by Condition.GetName
+func (r *ConditionRegistry) RegisterCondition(name string, factory func() ladon.Condition) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.factories[name] = factory
+}
+
+// GetFactory returns the factory for the given condition type
+func (r *ConditionRegistry) GetFactory(name string) (func() ladon.Condition, bool) {
+	r.mu.RLock()
+	factory, exists := r.factories[name]
+	r.mu.RUnlock()
+	if exists {
+		return factory, true
+	}
+	factory, exists = ladon.ConditionFactories[name]
+	return factory, exists
+}
+
+// GetSupportedTypes returns all condition types that can be decoded, sorted by name
+func (r *ConditionRegistry) GetSupportedTypes() []string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	types := make([]string, 0, len(r.factories)+len(ladon.ConditionFactories))
+	for name := range r.factories {
+		types = append(types, name)
+	}
+	for name := range ladon.ConditionFactories {
+		if _, exists := r.factories[name]; !exists {
+			types = append(types, name)
+		}
+	}
+	sort.Strings(types)
+	return types
+}
+
+// RegisterCondition makes the manager decode conditions of a custom type
+func (s *SQLManager) RegisterCondition(name string, factory func() ladon.Condition) {
+	s.conditionRegistry.RegisterCondition(name, factory)
+}
+
+// jsonCondition is the stored form of a condition, as written by ladon.Conditions
+type jsonCondition struct {
+	Type    string          `json:"type"`
+	Options json.RawMessage `json:"options"`
+}
+
+// decodeConditions decodes the stored conditions of a policy. Unknown condition
+// types and corrupt JSON are returned as ConditionError rather than dropped, so a
+// conditional policy never turns into an unconditional one.
+func (s *SQLManager) decodeConditions(policyID string, data []byte) (ladon.Conditions, error) {
+	conditions := ladon.Conditions{}
+	if len(data) == 0 {
+		return conditions, nil
+	}
+
+	var stored map[string]jsonCondition
+	if err := json.Unmarshal(data, &stored); err != nil {
+		return nil, errors.WithStack(&ConditionError{PolicyID: policyID, Err: err})
+	}
+
+	for key, jc := range stored {
+		factory, exists := s.conditionRegistry.GetFactory(jc.Type)
+		if !exists {
+			return nil, errors.WithStack(&ConditionError{
+				PolicyID: policyID,
+				Key:      key,
+				Err:      errors.Errorf("unknown condition type %q", jc.Type),
+			})
+		}
+
+		condition := factory()
+		if len(jc.Options) > 0 {
+			if err := json.Unmarshal(jc.Options, condition); err != nil {
+				return nil, errors.WithStack(&ConditionError{PolicyID: policyID, Key: key, Err: err})
+			}
+		}
+		conditions[key] = condition
+	}
+	return conditions, nil
+}
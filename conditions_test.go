@@ -0,0 +1,94 @@
+package ladonsqlmanager
+
+import (
+	"context"
+	"testing"
+
+	"github.com/ladonsqlmanager/models"
+	"github.com/ory/ladon"
+	"github.com/pkg/errors"
+)
+
+// ownerCondition is a custom condition type unknown to ladon
+type ownerCondition struct {
+	Owner string `json:"owner"`
+}
+
+func (c *ownerCondition) GetName() string { return "OwnerCondition" }
+
+func (c *ownerCondition) Fulfills(_ context.Context, value interface{}, _ *ladon.Request) bool {
+	return value == c.Owner
+}
+
+func TestSQLiteCustomConditions(t *testing.T) {
+	manager := newTestManager(t)
+	ctx := context.Background()
+
+	policy := &ladon.DefaultPolicy{
+		ID:          "1",
+		Description: "owners can read their articles",
+		Subjects:    []string{"user"},
+		Effect:      ladon.AllowAccess,
+		Resources:   []string{"article:1"},
+		Actions:     []string{"read"},
+		Conditions:  ladon.Conditions{"owner": &ownerCondition{Owner: "user"}},
+	}
+
+	var conditionErr *ConditionError
+	if err := manager.Create(ctx, policy); !errors.As(err, &conditionErr) {
+		t.Fatalf("Expected a ConditionError for an unregistered condition type, got %v", err)
+	}
+	if conditionErr.Key != "owner" {
+		t.Errorf("Expected invalid condition owner, got %s", conditionErr.Key)
+	}
+
+	manager.RegisterCondition("OwnerCondition", func() ladon.Condition { return new(ownerCondition) })
+	createTestPolicies(t, manager, policy)
+
+	stored, err := manager.Get(ctx, "1")
+	if err != nil {
+		t.Fatalf("Failed to get policy: %v", err)
+	}
+	condition, ok := stored.GetConditions()["owner"].(*ownerCondition)
+	if !ok || condition.Owner != "user" {
+		t.Errorf("Expected owner condition to be decoded, got %v", stored.GetConditions())
+	}
+}
+
+func TestSQLiteUndecodableConditions(t *testing.T) {
+	manager := newTestManager(t)
+	ctx := context.Background()
+
+	createTestPolicies(t, manager, &ladon.DefaultPolicy{
+		ID:          "1",
+		Description: "users can read articles",
+		Subjects:    []string{"user"},
+		Effect:      ladon.AllowAccess,
+		Resources:   []string{"article:1"},
+		Actions:     []string{"read"},
+	})
+
+	for _, conditions := range []string{
+		`{"owner":{"type":"OwnerCondition","options":{"owner":"user"}}}`,
+		`{"owner":`,
+	} {
+		err := manager.db.Model(&models.Policy{}).
+			Where("id = ?", "1").
+			Update("conditions", conditions).Error
+		if err != nil {
+			t.Fatalf("Failed to corrupt conditions: %v", err)
+		}
+
+		var conditionErr *ConditionError
+		if _, err := manager.Get(ctx, "1"); !errors.As(err, &conditionErr) || conditionErr.PolicyID != "1" {
+			t.Errorf("Expected a ConditionError from Get for %s, got %v", conditions, err)
+		}
+		if _, err := manager.GetAll(ctx, 10, 0); !errors.As(err, &conditionErr) {
+			t.Errorf("Expected a ConditionError from GetAll for %s, got %v", conditions, err)
+		}
+		request := &ladon.Request{Subject: "user", Action: "read", Resource: "article:1"}
+		if _, err := manager.FindRequestCandidates(ctx, request); !errors.As(err, &conditionErr) {
+			t.Errorf("Expected a ConditionError from FindRequestCandidates for %s, got %v", conditions, err)
+		}
+	}
+}
@@ -7,6 +7,7 @@ import (
 // EntityFactory defines the interface for creating entities and their relationships
 type EntityFactory interface {
 	CreateEntity(baseEntity models.BaseEntity) interface{}
+	CreateEntities(baseEntities []models.BaseEntity) interface{}
 	CreateRelation(policyID, entityID string) interface{}
 	GetEntityType() string
 	GetRelationStrategy() RelationStrategy
@@ -22,6 +23,15 @@ func (f *SubjectFactory) CreateEntity(baseEntity models.BaseEntity) interface{}
 	return &models.Subject{BaseEntity: baseEntity}
 }
 
+// CreateEntities creates a slice of Subject entities suitable for batch inserts
+func (f *SubjectFactory) CreateEntities(baseEntities []models.BaseEntity) interface{} {
+	entities := make([]models.Subject, len(baseEntities))
+	for i, baseEntity := range baseEntities {
+		entities[i] = models.Subject{BaseEntity: baseEntity}
+	}
+	return entities
+}
+
 // CreateRelation creates a new PolicySubjectRel relationship
 func (f *SubjectFactory) CreateRelation(policyID, entityID string) interface{} {
 	return &models.PolicySubjectRel{
@@ -53,6 +63,15 @@ func (f *ActionFactory) CreateEntity(baseEntity models.BaseEntity) interface{} {
 	return &models.Action{BaseEntity: baseEntity}
 }
 
+// CreateEntities creates a slice of Action entities suitable for batch inserts
+func (f *ActionFactory) CreateEntities(baseEntities []models.BaseEntity) interface{} {
+	entities := make([]models.Action, len(baseEntities))
+	for i, baseEntity := range baseEntities {
+		entities[i] = models.Action{BaseEntity: baseEntity}
+	}
+	return entities
+}
+
 // CreateRelation creates a new PolicyActionRel relationship
 func (f *ActionFactory) CreateRelation(policyID, entityID string) interface{} {
 	return &models.PolicyActionRel{
@@ -84,6 +103,15 @@ func (f *ResourceFactory) CreateEntity(baseEntity models.BaseEntity) interface{}
 	return &models.Resource{BaseEntity: baseEntity}
 }
 
+// CreateEntities creates a slice of Resource entities suitable for batch inserts
+func (f *ResourceFactory) CreateEntities(baseEntities []models.BaseEntity) interface{} {
+	entities := make([]models.Resource, len(baseEntities))
+	for i, baseEntity := range baseEntities {
+		entities[i] = models.Resource{BaseEntity: baseEntity}
+	}
+	return entities
+}
+
 // CreateRelation creates a new PolicyResourceRel relationship
 func (f *ResourceFactory) CreateRelation(policyID, entityID string) interface{} {
 	return &models.PolicyResourceRel{
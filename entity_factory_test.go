@@ -130,6 +130,37 @@ func TestResourceFactory(t *testing.T) {
 	}
 }
 
+func TestFactoryCreateEntities(t *testing.T) {
+	baseEntities := []models.BaseEntity{
+		{ID: "id-1", Template: "a", Compiled: "^a$"},
+		{ID: "id-2", Template: "b", Compiled: "^b$"},
+	}
+
+	subjects, ok := (&SubjectFactory{}).CreateEntities(baseEntities).([]models.Subject)
+	if !ok {
+		t.Fatal("Expected []models.Subject")
+	}
+	if len(subjects) != 2 || subjects[1].ID != "id-2" {
+		t.Errorf("Expected 2 subjects ending with 'id-2', got %+v", subjects)
+	}
+
+	actions, ok := (&ActionFactory{}).CreateEntities(baseEntities).([]models.Action)
+	if !ok {
+		t.Fatal("Expected []models.Action")
+	}
+	if len(actions) != 2 || actions[0].ID != "id-1" {
+		t.Errorf("Expected 2 actions starting with 'id-1', got %+v", actions)
+	}
+
+	resources, ok := (&ResourceFactory{}).CreateEntities(nil).([]models.Resource)
+	if !ok {
+		t.Fatal("Expected []models.Resource")
+	}
+	if len(resources) != 0 {
+		t.Errorf("Expected no resources, got %d", len(resources))
+	}
+}
+
 func TestEntityFactoryRegistry(t *testing.T) {
 	registry := NewEntityFactoryRegistry()
 
@@ -0,0 +1,92 @@
+package ladonsqlmanager
+
+import (
+	"fmt"
+
+	"github.com/ladonsqlmanager/models"
+	"github.com/ory/ladon"
+	"github.com/pkg/errors"
+	"gorm.io/gorm"
+)
+
+// ValidationError describes a policy field that failed validation
+type ValidationError = models.ValidationError
+
+// TemplateError names a subject, action or resource template that failed to compile
+type TemplateError struct {
+	PolicyID string
+	// Type is "subject", "action" or "resource"
+	Type     string
+	Template string
+	Err      error
+}
+
+func (e *TemplateError) Error() string {
+	return fmt.Sprintf("invalid %s template %q of policy %s: %v", e.Type, e.Template, e.PolicyID, e.Err)
+}
+
+// Unwrap returns the compile error, or the reason the template was rejected
+func (e *TemplateError) Unwrap() error {
+	return e.Err
+}
+
+// ConditionError reports conditions of a policy that cannot be decoded, e.g.
+// because their type is not registered
+type ConditionError struct {
+	PolicyID string
+	// Key is the name of the condition within the policy, empty when the whole
+	// conditions document is corrupt
+	Key string
+	Err error
+}
+
+func (e *ConditionError) Error() string {
+	if e.Key == "" {
+		return fmt.Sprintf("invalid conditions of policy %s: %v", e.PolicyID, e.Err)
+	}
+	return fmt.Sprintf("invalid condition %q of policy %s: %v", e.Key, e.PolicyID, e.Err)
+}
+
+// Unwrap returns the decoding error
+func (e *ConditionError) Unwrap() error {
+	return e.Err
+}
+
+// notFoundError is the type of ErrPolicyNotFound. It unwraps to ladon.ErrNotFound
+// so callers checking for ladon's error keep working.
+type notFoundError struct {
+	message string
+}
+
+func (e *notFoundError) Error() string {
+	return e.message
+}
+
+// Unwrap makes errors.Is match ladon.ErrNotFound
+func (e *notFoundError) Unwrap() error {
+	return ladon.ErrNotFound
+}
+
+// Cause makes errors.Cause return ladon.ErrNotFound
+func (e *notFoundError) Cause() error {
+	return ladon.ErrNotFound
+}
+
+// isUniqueViolation reports whether err is a unique constraint violation. The
+// dialector translates the driver specific codes, e.g. 23505 on Postgres and 1062
+// on MySQL, whether or not gorm.Config.TranslateError is set.
+func (s *SQLManager) isUniqueViolation(err error) bool {
+	if errors.Is(err, gorm.ErrDuplicatedKey) {
+		return true
+	}
+	translator, ok := s.db.Dialector.(gorm.ErrorTranslator)
+	if !ok {
+		return false
+	}
+	for ; err != nil; err = errors.Unwrap(err) {
+		if errors.Is(translator.Translate(err), gorm.ErrDuplicatedKey) {
+			return true
+		}
+	}
+	return false
+}
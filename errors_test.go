@@ -0,0 +1,179 @@
+package ladonsqlmanager
+
+import (
+	"context"
+	"testing"
+
+	"github.com/ory/ladon"
+	"github.com/pkg/errors"
+)
+
+func TestSQLiteErrors(t *testing.T) {
+	manager := newTestManager(t)
+	ctx := context.Background()
+
+	policy := &ladon.DefaultPolicy{
+		ID:          "1",
+		Description: "users can read articles",
+		Subjects:    []string{"user"},
+		Effect:      ladon.AllowAccess,
+		Resources:   []string{"article:1"},
+		Actions:     []string{"read"},
+	}
+	createTestPolicies(t, manager, policy)
+
+	if err := manager.Create(ctx, policy); !errors.Is(err, ErrPolicyExists) {
+		t.Errorf("Expected ErrPolicyExists for a duplicate, got %v", err)
+	}
+
+	report, err := manager.CreateMany(ctx, []ladon.Policy{policy})
+	if err != nil {
+		t.Fatalf("Failed to create policies: %v", err)
+	}
+	if !errors.Is(report[0].Err, ErrPolicyExists) {
+		t.Errorf("Expected ErrPolicyExists in the bulk report, got %v", report[0].Err)
+	}
+
+	missing := &ladon.DefaultPolicy{
+		ID:          "missing",
+		Description: "not stored",
+		Subjects:    []string{"user"},
+		Effect:      ladon.AllowAccess,
+		Resources:   []string{"article:1"},
+		Actions:     []string{"read"},
+	}
+	_, getErr := manager.Get(ctx, "missing")
+	for name, err := range map[string]error{
+		"Get":    getErr,
+		"Update": manager.Update(ctx, missing),
+		"Delete": manager.Delete(ctx, "missing"),
+	} {
+		if !errors.Is(err, ErrPolicyNotFound) {
+			t.Errorf("Expected ErrPolicyNotFound from %s, got %v", name, err)
+		}
+		if !errors.Is(err, ladon.ErrNotFound) || errors.Cause(err) != ladon.ErrNotFound {
+			t.Errorf("Expected %s error to match ladon.ErrNotFound, got %v", name, err)
+		}
+	}
+
+	invalid := &ladon.DefaultPolicy{
+		ID:        "2",
+		Subjects:  []string{"user"},
+		Effect:    ladon.AllowAccess,
+		Resources: []string{"article:1"},
+		Actions:   []string{"read"},
+	}
+	var validationErr *ValidationError
+	if err := manager.Create(ctx, invalid); !errors.As(err, &validationErr) {
+		t.Fatalf("Expected a ValidationError, got %v", err)
+	}
+	if validationErr.Field != "description" {
+		t.Errorf("Expected invalid field description, got %s", validationErr.Field)
+	}
+}
+
+func TestSQLiteUpdateSoftDeleted(t *testing.T) {
+	manager := newTestManager(t)
+	ctx := context.Background()
+
+	policy := &ladon.DefaultPolicy{
+		ID:          "1",
+		Description: "users can read articles",
+		Subjects:    []string{"user"},
+		Effect:      ladon.AllowAccess,
+		Resources:   []string{"article:1"},
+		Actions:     []string{"read"},
+	}
+	createTestPolicies(t, manager, policy)
+	if err := manager.Delete(ctx, "1"); err != nil {
+		t.Fatalf("Failed to delete policy: %v", err)
+	}
+
+	policy.Description = "restored by update"
+	if err := manager.Update(ctx, policy); err != nil {
+		t.Fatalf("Expected soft-deleted policy to be replaced, got %v", err)
+	}
+	stored, err := manager.Get(ctx, "1")
+	if err != nil {
+		t.Fatalf("Failed to get policy: %v", err)
+	}
+	if stored.GetDescription() != "restored by update" {
+		t.Errorf("Expected updated description, got %s", stored.GetDescription())
+	}
+}
+
+func TestSQLiteInvalidTemplates(t *testing.T) {
+	ctx := context.Background()
+	policy := &ladon.DefaultPolicy{
+		ID:          "1",
+		Description: "users can read files",
+		Subjects:    []string{"user"},
+		Effect:      ladon.AllowAccess,
+		Resources:   []string{"file:<[a-z>", "file:readme"},
+		Actions:     []string{"read"},
+	}
+
+	manager := newTestManager(t)
+	err := manager.Create(ctx, policy)
+	var templateErr *TemplateError
+	if !errors.As(err, &templateErr) {
+		t.Fatalf("Expected a TemplateError, got %v", err)
+	}
+	if templateErr.Type != "resource" || templateErr.Template != "file:<[a-z>" {
+		t.Errorf("Expected invalid resource template file:<[a-z>, got %s %s", templateErr.Type, templateErr.Template)
+	}
+	if _, err := manager.Get(ctx, "1"); !errors.Is(err, ErrPolicyNotFound) {
+		t.Errorf("Expected rejected policy not to be stored, got %v", err)
+	}
+
+	config := DefaultConfig()
+	config.LenientTemplates = true
+	lenient := newTestManagerWithConfig(t, config)
+
+	report, err := lenient.CreateMany(ctx, []ladon.Policy{policy})
+	if err != nil {
+		t.Fatalf("Failed to create policies: %v", err)
+	}
+	if report[0].Err != nil {
+		t.Fatalf("Expected policy to be stored, got %v", report[0].Err)
+	}
+	if len(report[0].Skipped) != 1 || report[0].Skipped[0].Template != "file:<[a-z>" {
+		t.Errorf("Expected invalid template to be reported as skipped, got %v", report[0].Skipped)
+	}
+
+	stored, err := lenient.Get(ctx, "1")
+	if err != nil {
+		t.Fatalf("Failed to get policy: %v", err)
+	}
+	if resources := stored.GetResources(); len(resources) != 1 || resources[0] != "file:readme" {
+		t.Errorf("Expected only the valid resource to be stored, got %v", resources)
+	}
+
+	// Create and Update store the policy, the report variants return the skipped templates
+	lenient = newTestManagerWithConfig(t, config)
+	if err := lenient.Create(ctx, policy); err != nil {
+		t.Fatalf("Expected Create to store the policy, got %v", err)
+	}
+	if err := lenient.Update(ctx, policy); err != nil {
+		t.Fatalf("Expected Update to store the policy, got %v", err)
+	}
+	lenient = newTestManagerWithConfig(t, config)
+	for _, step := range []struct {
+		name  string
+		store func(context.Context, ladon.Policy) ([]*TemplateError, error)
+	}{
+		{"CreateWithReport", lenient.CreateWithReport},
+		{"UpdateWithReport", lenient.UpdateWithReport},
+	} {
+		skipped, err := step.store(ctx, policy)
+		if err != nil {
+			t.Fatalf("Expected %s to store the policy, got %v", step.name, err)
+		}
+		if len(skipped) != 1 || skipped[0].Type != "resource" || skipped[0].Template != "file:<[a-z>" {
+			t.Errorf("Expected %s to report the skipped resource file:<[a-z>, got %+v", step.name, skipped)
+		}
+		if _, err := lenient.Get(ctx, "1"); err != nil {
+			t.Errorf("Expected %s to store the policy, got %v", step.name, err)
+		}
+	}
+}
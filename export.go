@@ -0,0 +1,423 @@
+package ladonsqlmanager
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/ladonsqlmanager/models"
+	"github.com/ory/ladon"
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v3"
+	"gorm.io/gorm"
+)
+
+// Format is the encoding of exported policies
+type Format string
+
+const (
+	// FormatJSON encodes policies as a JSON array
+	FormatJSON Format = "json"
+	// FormatYAML encodes policies as a YAML sequence
+	FormatYAML Format = "yaml"
+)
+
+// FormatFromExtension returns the format of a policy file with the given path:
+// FormatYAML for .yaml and .yml files, FormatJSON for .json files
+func FormatFromExtension(path string) (Format, bool) {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		return FormatYAML, true
+	case ".json":
+		return FormatJSON, true
+	default:
+		return "", false
+	}
+}
+
+// ImportMode selects how Import treats policies that already exist
+type ImportMode string
+
+const (
+	// ImportCreateOnly creates the imported policies and reports ErrPolicyExists for
+	// those that already exist
+	ImportCreateOnly ImportMode = "create-only"
+	// ImportUpsert creates the missing policies and updates the existing ones
+	ImportUpsert ImportMode = "upsert"
+	// ImportReplaceAll upserts the imported policies and deletes all others
+	ImportReplaceAll ImportMode = "replace-all"
+)
+
+// ImportAction is what Import did with a policy
+type ImportAction string
+
+const (
+	// ImportCreated marks a policy that did not exist before the import
+	ImportCreated ImportAction = "created"
+	// ImportUpdated marks an existing policy overwritten by the import
+	ImportUpdated ImportAction = "updated"
+	// ImportDeleted marks a policy deleted because ImportReplaceAll did not list it
+	ImportDeleted ImportAction = "deleted"
+)
+
+// ImportResult is the outcome of Import for a single policy. Action is what Import
+// did with the policy, or tried to do when Err is set.
+type ImportResult struct {
+	ID     string
+	Action ImportAction
+	Err    error
+	// Skipped lists the templates left out of the stored policy when
+	// Config.LenientTemplates is set
+	Skipped []*TemplateError
+}
+
+// ImportReport lists the imported policies in the order they were read, followed
+// by the policies deleted by ImportReplaceAll
+type ImportReport []ImportResult
+
+// Failed returns the results of the policies that were not imported or deleted
+func (r ImportReport) Failed() ImportReport {
+	failed := make(ImportReport, 0)
+	for _, result := range r {
+		if result.Err != nil {
+			failed = append(failed, result)
+		}
+	}
+	return failed
+}
+
+// policyDocument is the exported form of a policy. Conditions and meta hold decoded
+// JSON, so that they are readable in both formats.
+type policyDocument struct {
+	ID             string      `json:"id" yaml:"id"`
+	Description    string      `json:"description" yaml:"description"`
+	Subjects       []string    `json:"subjects" yaml:"subjects"`
+	Effect         string      `json:"effect" yaml:"effect"`
+	Resources      []string    `json:"resources" yaml:"resources"`
+	Actions        []string    `json:"actions" yaml:"actions"`
+	Conditions     interface{} `json:"conditions,omitempty" yaml:"conditions,omitempty"`
+	Meta           interface{} `json:"meta,omitempty" yaml:"meta,omitempty"`
+	StartDelimiter string      `json:"start_delimiter,omitempty" yaml:"start_delimiter,omitempty"`
+	EndDelimiter   string      `json:"end_delimiter,omitempty" yaml:"end_delimiter,omitempty"`
+}
+
+// newPolicyDocument converts a policy into its exported form. Meta must hold JSON,
+// as it does for every policy read from the database.
+func newPolicyDocument(policy ladon.Policy) (policyDocument, error) {
+	doc := policyDocument{
+		ID:          policy.GetID(),
+		Description: policy.GetDescription(),
+		Subjects:    policy.GetSubjects(),
+		Effect:      policy.GetEffect(),
+		Resources:   policy.GetResources(),
+		Actions:     policy.GetActions(),
+	}
+
+	if len(policy.GetConditions()) > 0 {
+		conditions, err := json.Marshal(policy.GetConditions())
+		if err != nil {
+			return doc, errors.Wrapf(err, "failed to encode the conditions of policy %s", doc.ID)
+		}
+		if err := json.Unmarshal(conditions, &doc.Conditions); err != nil {
+			return doc, errors.WithStack(err)
+		}
+	}
+
+	if meta := policy.GetMeta(); len(meta) > 0 && string(meta) != "{}" {
+		if err := json.Unmarshal(meta, &doc.Meta); err != nil {
+			return doc, errors.Wrapf(err, "meta of policy %s is not JSON", doc.ID)
+		}
+	}
+
+	if policy.GetStartDelimiter() != models.DefaultStartDelimiter || policy.GetEndDelimiter() != models.DefaultEndDelimiter {
+		doc.StartDelimiter = string(policy.GetStartDelimiter())
+		doc.EndDelimiter = string(policy.GetEndDelimiter())
+	}
+	return doc, nil
+}
+
+// policyFromDocument converts the document back into a policy, decoding conditions
+// with the condition types known to the manager
+func (s *SQLManager) policyFromDocument(doc policyDocument) (ladon.Policy, error) {
+	policy := ladon.DefaultPolicy{
+		ID:          doc.ID,
+		Description: doc.Description,
+		Subjects:    doc.Subjects,
+		Effect:      doc.Effect,
+		Resources:   doc.Resources,
+		Actions:     doc.Actions,
+		Conditions:  ladon.Conditions{},
+	}
+
+	if doc.Conditions != nil {
+		conditions, err := json.Marshal(doc.Conditions)
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid conditions of policy %s", doc.ID)
+		}
+		if policy.Conditions, err = s.decodeConditions(doc.ID, conditions); err != nil {
+			return nil, err
+		}
+	}
+
+	if doc.Meta != nil {
+		meta, err := json.Marshal(doc.Meta)
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid meta of policy %s", doc.ID)
+		}
+		policy.Meta = meta
+	}
+
+	if doc.StartDelimiter == "" && doc.EndDelimiter == "" {
+		return &policy, nil
+	}
+	if len(doc.StartDelimiter) != 1 || len(doc.EndDelimiter) != 1 {
+		return nil, errors.Errorf("delimiters of policy %s must be single characters", doc.ID)
+	}
+	return &DelimitedPolicy{
+		DefaultPolicy:  policy,
+		StartDelimiter: doc.StartDelimiter[0],
+		EndDelimiter:   doc.EndDelimiter[0],
+	}, nil
+}
+
+// EncodePolicies writes the policies to w in the given format, including their
+// conditions, meta and custom delimiters
+func EncodePolicies(w io.Writer, format Format, policies ladon.Policies) error {
+	docs := make([]policyDocument, len(policies))
+	for i, policy := range policies {
+		doc, err := newPolicyDocument(policy)
+		if err != nil {
+			return err
+		}
+		docs[i] = doc
+	}
+
+	switch format {
+	case FormatJSON:
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		encoder.SetEscapeHTML(false)
+		return errors.WithStack(encoder.Encode(docs))
+	case FormatYAML:
+		encoder := yaml.NewEncoder(w)
+		encoder.SetIndent(2)
+		if err := encoder.Encode(docs); err != nil {
+			return errors.WithStack(err)
+		}
+		return errors.WithStack(encoder.Close())
+	default:
+		return errors.Wrapf(ErrUnknownFormat, "%q", format)
+	}
+}
+
+// DecodePolicies reads policies written by EncodePolicies from r. Conditions may be
+// of any type registered with RegisterCondition.
+func (s *SQLManager) DecodePolicies(r io.Reader, format Format) (ladon.Policies, error) {
+	var docs []policyDocument
+	switch format {
+	case FormatJSON:
+		decoder := json.NewDecoder(r)
+		decoder.DisallowUnknownFields()
+		if err := decoder.Decode(&docs); err != nil {
+			return nil, errors.Wrap(err, "failed to decode policies")
+		}
+	case FormatYAML:
+		decoder := yaml.NewDecoder(r)
+		decoder.KnownFields(true)
+		if err := decoder.Decode(&docs); err != nil && err != io.EOF {
+			return nil, errors.Wrap(err, "failed to decode policies")
+		}
+	default:
+		return nil, errors.Wrapf(ErrUnknownFormat, "%q", format)
+	}
+
+	policies := make(ladon.Policies, len(docs))
+	seen := make(map[string]bool, len(docs))
+	for i, doc := range docs {
+		if seen[doc.ID] {
+			return nil, errors.Errorf("policy %s is listed twice", doc.ID)
+		}
+		seen[doc.ID] = true
+
+		policy, err := s.policyFromDocument(doc)
+		if err != nil {
+			return nil, err
+		}
+		policies[i] = policy
+	}
+	return policies, nil
+}
+
+// Export writes all policies, ordered by ID, to w in the given format and returns
+// how many were written
+func (s *SQLManager) Export(ctx context.Context, w io.Writer, format Format) (_ int, err error) {
+	ctx, op := s.startOperation(ctx, "Export")
+	defer func() { op.finish(err) }()
+
+	stored, err := s.findPolicyModels(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	policies, err := s.convertPoliciesToLadon(stored)
+	if err != nil {
+		return 0, err
+	}
+	op.metrics.Rows = len(policies)
+
+	if err := EncodePolicies(w, format, policies); err != nil {
+		return 0, err
+	}
+	return len(policies), nil
+}
+
+// policyIDs returns the IDs of all policies that are not deleted
+func (s *SQLManager) policyIDs(ctx context.Context) ([]string, error) {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	var ids []string
+	if err := s.db.WithContext(ctx).Model(&models.Policy{}).Pluck("id", &ids).Error; err != nil {
+		return nil, timeoutError(errors.WithStack(err))
+	}
+	return ids, nil
+}
+
+// Import reads policies written by Export from r and stores them with CreateMany or
+// UpsertMany, depending on mode. Like with those, a failing policy is only reported
+// in the ImportReport. ImportReplaceAll instead stores the policies of r and deletes
+// all others in a single transaction. It returns ErrReplaceAborted without changing
+// anything when an imported policy failed.
+func (s *SQLManager) Import(ctx context.Context, r io.Reader, format Format, mode ImportMode) (report ImportReport, err error) {
+	ctx, op := s.startOperation(ctx, "Import")
+	defer func() {
+		op.metrics.Rows = len(report) - len(report.Failed())
+		op.finish(err)
+	}()
+
+	if mode != ImportCreateOnly && mode != ImportUpsert && mode != ImportReplaceAll {
+		return nil, errors.Wrapf(ErrUnknownImportMode, "%q", mode)
+	}
+
+	policies, err := s.DecodePolicies(r, format)
+	if err != nil {
+		return nil, err
+	}
+
+	ids, err := s.policyIDs(ctx)
+	if err != nil {
+		return nil, err
+	}
+	existing := make(map[string]bool, len(ids))
+	for _, id := range ids {
+		existing[id] = true
+	}
+
+	if mode == ImportReplaceAll {
+		return s.replaceAll(ctx, policies, ids, existing)
+	}
+
+	var bulk BulkReport
+	if mode == ImportCreateOnly {
+		bulk, err = s.CreateMany(ctx, policies)
+	} else {
+		bulk, err = s.UpsertMany(ctx, policies)
+	}
+
+	report = make(ImportReport, len(bulk))
+	for i, result := range bulk {
+		action := ImportCreated
+		if existing[result.ID] {
+			action = ImportUpdated
+		}
+		report[i] = ImportResult{ID: result.ID, Action: action, Err: result.Err, Skipped: result.Skipped}
+	}
+	return report, err
+}
+
+// replaceAll upserts policies and deletes the stored policies ids missing from them
+// in one transaction, like ApplySync. When anything fails the transaction is rolled
+// back and every result without an error of its own reports the returned error.
+func (s *SQLManager) replaceAll(ctx context.Context, policies []ladon.Policy, ids []string, existing map[string]bool) (ImportReport, error) {
+	report := make(ImportReport, len(policies))
+	prepared := make([]*preparedPolicy, len(policies))
+	imported := make(map[string]bool, len(policies))
+	items := make([]policyItems, 0, 3*len(policies))
+	for i, policy := range policies {
+		report[i] = ImportResult{ID: policy.GetID(), Action: ImportCreated}
+		if existing[report[i].ID] {
+			report[i].Action = ImportUpdated
+		}
+		imported[report[i].ID] = true
+
+		prepared[i], report[i].Err = s.preparePolicy(policy)
+		if prepared[i] != nil {
+			report[i].Skipped = prepared[i].skipped
+			items = append(items, prepared[i].items...)
+		}
+	}
+
+	// Validation failures abort the import without touching the database
+	if len(report.Failed()) > 0 {
+		return report, abortReport(report, errors.WithStack(ErrReplaceAborted))
+	}
+
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	err := s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := s.insertEntities(items, tx); err != nil {
+			return err
+		}
+
+		// Each policy is stored in its own savepoint, so that all failing policies
+		// are reported
+		for i := range policies {
+			report[i].Err = timeoutError(tx.Transaction(func(ptx *gorm.DB) error {
+				err := s.update(prepared[i], ptx)
+				if errors.Is(err, ErrPolicyNotFound) {
+					err = s.insertPolicy(prepared[i], ptx)
+				}
+				if err != nil {
+					return err
+				}
+				return s.publishChange(ptx, report[i].ID)
+			}))
+		}
+		if len(report.Failed()) > 0 {
+			return errors.WithStack(ErrReplaceAborted)
+		}
+
+		sort.Strings(ids)
+		for _, id := range ids {
+			if imported[id] {
+				continue
+			}
+			report = append(report, ImportResult{ID: id, Action: ImportDeleted})
+			if _, err := s.delete(id, tx); err != nil {
+				return err
+			}
+			if err := s.publishChange(tx, id); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return report, abortReport(report, timeoutError(err))
+	}
+	return report, nil
+}
+
+// abortReport sets err on the results of report that have no error of their own,
+// as none of them were stored, and returns err
+func abortReport(report ImportReport, err error) error {
+	for i := range report {
+		if report[i].Err == nil {
+			report[i].Err = err
+		}
+	}
+	return err
+}
@@ -0,0 +1,210 @@
+package ladonsqlmanager
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/ory/ladon"
+	"github.com/pkg/errors"
+)
+
+// exportTestPolicies covers conditions, meta and custom delimiters
+var exportTestPolicies = []ladon.Policy{
+	&ladon.DefaultPolicy{
+		ID:          "1",
+		Description: "editors can write articles",
+		Subjects:    []string{"editor", "users:<[0-9]+>"},
+		Effect:      ladon.AllowAccess,
+		Resources:   []string{"articles:<.*>"},
+		Actions:     []string{"write", "read"},
+		Conditions:  ladon.Conditions{"department": &ladon.StringEqualCondition{Equals: "editorial"}},
+		Meta:        []byte(`{"owner":"editorial","tags":["cms"]}`),
+	},
+	&DelimitedPolicy{
+		DefaultPolicy: ladon.DefaultPolicy{
+			ID:          "2",
+			Description: "nobody deletes drafts",
+			Subjects:    []string{"{.*}"},
+			Effect:      ladon.DenyAccess,
+			Resources:   []string{"drafts:{[0-9]+}"},
+			Actions:     []string{"delete"},
+			Conditions:  ladon.Conditions{},
+		},
+		StartDelimiter: '{',
+		EndDelimiter:   '}',
+	},
+}
+
+func TestSQLiteExportImport(t *testing.T) {
+	ctx := context.Background()
+	source := newTestManager(t)
+	createTestPolicies(t, source, exportTestPolicies...)
+
+	for _, format := range []Format{FormatJSON, FormatYAML} {
+		var exported bytes.Buffer
+		count, err := source.Export(ctx, &exported, format)
+		if err != nil {
+			t.Fatalf("Failed to export %s: %v", format, err)
+		}
+		if count != len(exportTestPolicies) {
+			t.Errorf("Expected %d exported policies, got %d", len(exportTestPolicies), count)
+		}
+
+		target := newTestManager(t)
+		report, err := target.Import(ctx, bytes.NewReader(exported.Bytes()), format, ImportCreateOnly)
+		if err != nil {
+			t.Fatalf("Failed to import %s: %v", format, err)
+		}
+		if len(report) != len(exportTestPolicies) || len(report.Failed()) != 0 {
+			t.Errorf("Expected all policies to be created, got %+v", report)
+		}
+
+		var reexported bytes.Buffer
+		if _, err := target.Export(ctx, &reexported, format); err != nil {
+			t.Fatalf("Failed to export %s again: %v", format, err)
+		}
+		if reexported.String() != exported.String() {
+			t.Errorf("Expected the %s export to survive an import, got\n%s\nexpected\n%s", format, reexported.String(), exported.String())
+		}
+
+		delimited, err := target.Get(ctx, "2")
+		if err != nil {
+			t.Fatalf("Failed to get policy: %v", err)
+		}
+		if delimited.GetStartDelimiter() != '{' || delimited.GetEndDelimiter() != '}' {
+			t.Errorf("Expected the custom delimiters to be imported, got %q and %q",
+				delimited.GetStartDelimiter(), delimited.GetEndDelimiter())
+		}
+	}
+
+	if _, err := source.Export(ctx, &bytes.Buffer{}, "xml"); !errors.Is(err, ErrUnknownFormat) {
+		t.Errorf("Expected ErrUnknownFormat, got %v", err)
+	}
+}
+
+func TestSQLiteImportModes(t *testing.T) {
+	ctx := context.Background()
+	manager := newTestManager(t)
+	createTestPolicies(t, manager, exportTestPolicies...)
+	createTestPolicies(t, manager, &ladon.DefaultPolicy{
+		ID:          "3",
+		Description: "not in the file",
+		Subjects:    []string{"guest"},
+		Effect:      ladon.AllowAccess,
+		Resources:   []string{"articles"},
+		Actions:     []string{"list"},
+	})
+
+	file := `
+- id: "1"
+  description: editors can edit articles
+  subjects: [editor]
+  effect: allow
+  resources: ["articles:<.*>"]
+  actions: [write]
+- id: "4"
+  description: admins can do anything
+  subjects: [admin]
+  effect: allow
+  resources: ["<.*>"]
+  actions: ["<.*>"]
+`
+
+	report, err := manager.Import(ctx, strings.NewReader(file), FormatYAML, ImportCreateOnly)
+	if err != nil {
+		t.Fatalf("Create-only import failed: %v", err)
+	}
+	if !errors.Is(report[0].Err, ErrPolicyExists) || report[1].Err != nil || report[1].Action != ImportCreated {
+		t.Errorf("Expected policy 1 to exist and policy 4 to be created, got %+v", report)
+	}
+
+	report, err = manager.Import(ctx, strings.NewReader(file), FormatYAML, ImportUpsert)
+	if err != nil {
+		t.Fatalf("Upsert import failed: %v", err)
+	}
+	if len(report.Failed()) != 0 || report[0].Action != ImportUpdated || report[1].Action != ImportUpdated {
+		t.Errorf("Expected both policies to be updated, got %+v", report)
+	}
+	policy, err := manager.Get(ctx, "1")
+	if err != nil {
+		t.Fatalf("Failed to get policy: %v", err)
+	}
+	if policy.GetDescription() != "editors can edit articles" || len(policy.GetConditions()) != 0 {
+		t.Errorf("Expected policy 1 to be replaced by the file, got %+v", policy)
+	}
+
+	report, err = manager.Import(ctx, strings.NewReader(file), FormatYAML, ImportReplaceAll)
+	if err != nil {
+		t.Fatalf("Replace-all import failed: %v", err)
+	}
+	if len(report) != 4 || report[2].ID != "2" || report[3].ID != "3" || report[3].Action != ImportDeleted {
+		t.Errorf("Expected policies 2 and 3 to be deleted, got %+v", report)
+	}
+	all, err := manager.GetAll(ctx, 10, 0)
+	if err != nil {
+		t.Fatalf("Failed to get policies: %v", err)
+	}
+	if len(all) != 2 {
+		t.Errorf("Expected only the imported policies to remain, got %d", len(all))
+	}
+
+	invalid := `[{"id": "5", "description": "no effect", "subjects": ["x"], "resources": ["y"], "actions": ["z"]}]`
+	report, err = manager.Import(ctx, strings.NewReader(invalid), FormatJSON, ImportReplaceAll)
+	if !errors.Is(err, ErrReplaceAborted) || len(report) != 1 {
+		t.Errorf("Expected ErrReplaceAborted for an invalid policy, got %v, %+v", err, report)
+	}
+	if all, _ := manager.GetAll(ctx, 10, 0); len(all) != 2 {
+		t.Errorf("Expected an aborted replace to delete nothing, got %d policies", len(all))
+	}
+
+	if _, err := manager.Import(ctx, strings.NewReader(file), FormatYAML, "merge"); !errors.Is(err, ErrUnknownImportMode) {
+		t.Errorf("Expected ErrUnknownImportMode, got %v", err)
+	}
+}
+
+func TestSQLiteImportReplaceAllRollback(t *testing.T) {
+	ctx := context.Background()
+	manager := newTestManager(t)
+	createTestPolicies(t, manager, &ladon.DefaultPolicy{
+		ID:          "literal",
+		Description: "a literal subject",
+		Subjects:    []string{"user:{[0-9]+}"},
+		Effect:      ladon.AllowAccess,
+		Resources:   []string{"article"},
+		Actions:     []string{"read"},
+	}, &ladon.DefaultPolicy{
+		ID:          "other",
+		Description: "not in the file",
+		Subjects:    []string{"guest"},
+		Effect:      ladon.AllowAccess,
+		Resources:   []string{"article"},
+		Actions:     []string{"list"},
+	})
+
+	// The pattern policy passes validation, but conflicts with the stored literal
+	// template when it is stored
+	file := `[
+		{"id": "literal", "description": "changed", "subjects": ["user:{[0-9]+}"], "effect": "allow", "resources": ["article"], "actions": ["read"]},
+		{"id": "pattern", "description": "a subject pattern", "subjects": ["user:{[0-9]+}"], "effect": "allow", "resources": ["article"], "actions": ["read"], "start_delimiter": "{", "end_delimiter": "}"}
+	]`
+	report, err := manager.Import(ctx, strings.NewReader(file), FormatJSON, ImportReplaceAll)
+	if !errors.Is(err, ErrReplaceAborted) {
+		t.Fatalf("Expected ErrReplaceAborted, got %v", err)
+	}
+	if len(report) != 2 || !errors.Is(report[0].Err, ErrReplaceAborted) || !errors.Is(report[1].Err, ErrTemplateConflict) {
+		t.Errorf("Expected the conflict to abort the whole import, got %+v", report)
+	}
+
+	policy, err := manager.Get(ctx, "literal")
+	if err != nil {
+		t.Fatalf("Failed to get policy: %v", err)
+	}
+	if policy.GetDescription() != "a literal subject" {
+		t.Errorf("Expected the update to be rolled back, got %q", policy.GetDescription())
+	}
+	if _, err := manager.Get(ctx, "other"); err != nil {
+		t.Errorf("Expected the missing policy to be kept, got %v", err)
+	}
+}
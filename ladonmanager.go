@@ -4,7 +4,8 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
-	"log"
+	"log/slog"
+	"sort"
 	"strings"
 	"time"
 
@@ -13,6 +14,7 @@ import (
 	"github.com/ory/ladon"
 	"github.com/pkg/errors"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
 const (
@@ -22,16 +24,54 @@ const (
 )
 
 var (
-	// ErrInvalidDriver returned if driver is not postgres or mysql
-	ErrInvalidDriver = errors.New("invalid drivername specified, must be mysql or postgres, pg, pgx")
+	// ErrInvalidDriver returned if driver is not postgres, mysql or sqlite
+	ErrInvalidDriver = errors.New("invalid drivername specified, must be mysql, sqlite, sqlite3 or postgres, pg, pgx")
 	// ErrInvalidPolicy returned when policy validation fails
 	ErrInvalidPolicy = errors.New("invalid policy")
 	// ErrEmptyPolicyID returned when policy ID is empty
-	ErrEmptyPolicyID = errors.New("policy ID cannot be empty")
+	ErrEmptyPolicyID error = &ValidationError{Field: "id", Reason: "cannot be empty"}
 	// ErrPolicyIDTooLong returned when policy ID exceeds maximum length
-	ErrPolicyIDTooLong = errors.New("policy ID exceeds maximum length")
+	ErrPolicyIDTooLong error = &ValidationError{Field: "id", Reason: "exceeds maximum length"}
+	// ErrPolicyExists returned when a policy with the same ID already exists
+	ErrPolicyExists = errors.New("policy already exists")
+	// ErrPolicyNotFound returned when no policy has the requested ID. It matches
+	// ladon.ErrNotFound with errors.Is and errors.Cause as well.
+	ErrPolicyNotFound error = &notFoundError{message: "policy not found"}
 	// ErrInvalidRelationType returned when relation type is invalid
 	ErrInvalidRelationType = errors.New("invalid relation type")
+	// ErrQueryTimeout returned when a query runs past Config.QueryTimeout or the
+	// deadline of the caller's context
+	ErrQueryTimeout = errors.New("query timed out")
+	// ErrImportTargetNotEmpty returned when ImportLegacy would copy into a database
+	// that already holds policies
+	ErrImportTargetNotEmpty = errors.New("import target already holds policies")
+	// ErrLegacyMismatch returned when imported legacy policies differ from the legacy data
+	ErrLegacyMismatch = errors.New("imported policies do not match the legacy schema")
+	// ErrUnknownFormat returned for export formats other than FormatJSON and FormatYAML
+	ErrUnknownFormat = errors.New("unknown policy format")
+	// ErrUnknownImportMode returned for import modes other than ImportCreateOnly,
+	// ImportUpsert and ImportReplaceAll
+	ErrUnknownImportMode = errors.New("unknown import mode")
+	// ErrReplaceAborted returned when Import in ImportReplaceAll mode changed nothing
+	// because some of the imported policies failed
+	ErrReplaceAborted = errors.New("policies failed to import, no policies were changed")
+	// ErrTemplateConflict returned when a template is already stored compiled with
+	// other delimiters
+	ErrTemplateConflict = errors.New("template is stored with other delimiters")
+	// ErrDuplicateTemplate returned when a policy lists a subject, action or resource
+	// template more than once, as it would be stored once and read back once
+	ErrDuplicateTemplate = errors.New("template is listed more than once")
+)
+
+// DeleteMode controls how SQLManager removes policies
+type DeleteMode int
+
+const (
+	// SoftDelete marks policies as deleted through gorm.DeletedAt and keeps their
+	// relation rows, so the policy can still be inspected or restored
+	SoftDelete DeleteMode = iota
+	// HardDelete removes policies together with their relation rows
+	HardDelete
 )
 
 // Config holds configuration options for SQLManager
@@ -40,6 +80,33 @@ type Config struct {
 	QueryTimeout       time.Duration
 	EnableMetrics      bool
 	SlowQueryThreshold time.Duration
+	// StrictCandidates makes FindRequestCandidates match the request's action and
+	// resource in the database as well, instead of returning every policy whose
+	// subject matches and leaving the rest to ladon
+	StrictCandidates bool
+	// DeleteMode selects between soft and hard deletes in Delete
+	DeleteMode DeleteMode
+	// Metrics receives latency, row counts, candidate counts and errors of every
+	// SQLManager call. Nil disables metrics.
+	Metrics MetricsRecorder
+	// BulkChunkSize is the number of policies CreateMany and UpsertMany store per
+	// transaction. Zero stores all policies in a single transaction.
+	BulkChunkSize int
+	// Tracer opens a span for every SQLManager call and a child span for every
+	// query it runs. Nil disables tracing.
+	Tracer Tracer
+	// Logger receives slow query warnings and debug records of every SQLManager
+	// call. Nil keeps the manager silent.
+	Logger *slog.Logger
+	// ChangeTransport announces the IDs of policies changed by this manager to other
+	// instances, e.g. to invalidate their caches. Nil disables announcements.
+	ChangeTransport ChangeTransport
+	// LenientTemplates stores policies without the subject, action and resource
+	// templates that fail to compile, instead of rejecting them with a TemplateError.
+	// Create and Update succeed for such policies. Skipped templates are logged as
+	// warnings, returned by CreateWithReport and UpdateWithReport and listed in
+	// BulkResult.Skipped.
+	LenientTemplates bool
 }
 
 // DefaultConfig returns a default configuration
@@ -49,18 +116,29 @@ func DefaultConfig() Config {
 		QueryTimeout:       30 * time.Second,
 		EnableMetrics:      false,
 		SlowQueryThreshold: 100 * time.Millisecond,
+		StrictCandidates:   false,
+		DeleteMode:         SoftDelete,
+		BulkChunkSize:      0,
+		Metrics:            nil,
+		Tracer:             nil,
+		Logger:             nil,
+		ChangeTransport:    nil,
+		LenientTemplates:   false,
 	}
 }
 
 // SQLManager implements the ladon/Manager without requiring sqlx or migrations packages
 type SQLManager struct {
-	db               *gorm.DB
-	driverName       string
-	config           Config
-	factoryRegistry  *EntityFactoryRegistry
-	builderDirector  *EntityBuilderDirector
-	strategyRegistry *RelationStrategyRegistry
-	typeDetector     *RelationTypeDetector
+	db                *gorm.DB
+	driverName        string
+	config            Config
+	factoryRegistry   *EntityFactoryRegistry
+	builderDirector   *EntityBuilderDirector
+	strategyRegistry  *RelationStrategyRegistry
+	typeDetector      *RelationTypeDetector
+	matcher           *templateMatcher
+	conditionRegistry *ConditionRegistry
+	logger            *slog.Logger
 }
 
 // New creates a new, uninitialized SQLManager with default configuration
@@ -71,57 +149,310 @@ func New(db *gorm.DB, driverName string) *SQLManager {
 // NewWithConfig creates a new SQLManager with custom configuration
 func NewWithConfig(db *gorm.DB, driverName string, config Config) *SQLManager {
 	strategyRegistry := NewRelationStrategyRegistry()
+
+	logger := config.Logger
+	if logger == nil {
+		logger = slog.New(slog.DiscardHandler)
+	}
+
+	if config.Tracer != nil && db != nil {
+		// Without the callbacks only the spans of the calls themselves are recorded
+		if err := registerTracingCallbacks(db); err != nil {
+			logger.Warn("Failed to register query tracing callbacks", "error", err)
+		}
+	}
+	if config.QueryTimeout > 0 && db != nil {
+		// Without the callbacks calls bounding their statements one by one are unbounded
+		if err := registerTimeoutCallbacks(db); err != nil {
+			logger.Warn("Failed to register statement timeout callbacks", "error", err)
+		}
+	}
 	return &SQLManager{
-		db:               db,
-		driverName:       strings.ToLower(driverName),
-		config:           config,
-		factoryRegistry:  NewEntityFactoryRegistry(),
-		builderDirector:  NewEntityBuilderDirector(),
-		strategyRegistry: strategyRegistry,
-		typeDetector:     NewRelationTypeDetector(strategyRegistry),
+		db:                db,
+		driverName:        strings.ToLower(driverName),
+		config:            config,
+		factoryRegistry:   NewEntityFactoryRegistry(),
+		builderDirector:   NewEntityBuilderDirector(),
+		strategyRegistry:  strategyRegistry,
+		typeDetector:      NewRelationTypeDetector(strategyRegistry),
+		matcher:           newTemplateMatcher(),
+		conditionRegistry: NewConditionRegistry(),
+		logger:            logger,
 	}
 }
 
 // Init ensures the database is properly initialized with GORM models
-func (s *SQLManager) Init() error {
+func (s *SQLManager) Init() (err error) {
+	_, op := s.startOperation(context.Background(), "Init")
+	defer func() { op.finish(err) }()
+
 	// Use the migration package to set up the database
-	return migrations.Migrate(s.db)
+	return migrations.Migrate(s.db, migrations.WithLogger(s.logger))
 }
 
-// Update updates a policy in the database by deleting original and re-creating
+// Update updates a policy in the database, writing only the fields and relations
+// that changed. A soft-deleted policy is replaced, a missing one returns
+// ErrPolicyNotFound.
 func (s *SQLManager) Update(ctx context.Context, policy ladon.Policy) error {
-	start := time.Now()
-	defer func() {
-		s.logSlowQuery("Update", time.Since(start))
-	}()
+	_, err := s.UpdateWithReport(ctx, policy)
+	return err
+}
+
+// UpdateWithReport updates a policy like Update and returns the templates left out
+// of the stored policy when Config.LenientTemplates is set
+func (s *SQLManager) UpdateWithReport(ctx context.Context, policy ladon.Policy) (_ []*TemplateError, err error) {
+	ctx, op := s.startOperation(ctx, "Update")
+	defer func() { op.finish(err) }()
+	op.span.SetAttribute(AttributePolicyID, policy.GetID())
+
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
 
-	return s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
-		if err := s.delete(policy.GetID(), tx); err != nil {
+	prepared, err := s.preparePolicy(policy)
+	if err != nil {
+		return nil, err
+	}
+
+	err = s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := s.update(prepared, tx); err != nil {
 			return err
 		}
-		return s.create(policy, tx)
+		return s.publishChange(tx, policy.GetID())
 	})
+	if err != nil {
+		return nil, timeoutError(err)
+	}
+	op.metrics.Rows = 1
+	return prepared.skipped, nil
+}
+
+func (s *SQLManager) update(prepared *preparedPolicy, tx *gorm.DB) error {
+	policyModel := prepared.model
+
+	var existing models.Policy
+	err := tx.
+		Where("id = ?", policyModel.ID).
+		First(&existing).Error
+
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		deleted, err := s.isDeleted(policyModel.ID, tx)
+		if err != nil {
+			return err
+		}
+		if !deleted {
+			return errors.WithStack(ErrPolicyNotFound)
+		}
+		// Nothing to diff against
+		if err := s.insertEntities(prepared.items, tx); err != nil {
+			return err
+		}
+		return s.insertPolicy(prepared, tx)
+	}
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	// Always write the columns so UpdatedAt is bumped, CreatedAt is left alone
+	err = tx.Model(&existing).Updates(map[string]interface{}{
+		"description":     policyModel.Description,
+		"effect":          policyModel.Effect,
+		"conditions":      policyModel.Conditions,
+		"meta":            policyModel.Meta,
+		"start_delimiter": policyModel.StartDelimiter,
+		"end_delimiter":   policyModel.EndDelimiter,
+	}).Error
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	// Sync subjects, actions, and resources against the current relations
+	ordinals, err := s.loadOrdinals(tx, []string{policyModel.ID})
+	if err != nil {
+		return err
+	}
+	current := make(map[string]map[string]int, len(prepared.items))
+	for key, ordinal := range ordinals {
+		if current[key.itemType] == nil {
+			current[key.itemType] = make(map[string]int)
+		}
+		current[key.itemType][key.entityID] = ordinal
+	}
+	for _, built := range prepared.items {
+		if err := s.syncPolicyItems(built, current[built.itemType], policyModel.ID, tx); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// syncPolicyItems links the policy to the built entities it is not linked to yet,
+// moves the ones whose template changed position and unlinks the current entities
+// that are no longer among them. current maps entity IDs to their ordinals.
+func (s *SQLManager) syncPolicyItems(built policyItems, current map[string]int, policyID string, tx *gorm.DB) error {
+	strategy, exists := s.strategyRegistry.GetStrategy(built.itemType)
+	if !exists {
+		return errors.Errorf("unsupported entity type: %s", built.itemType)
+	}
+
+	wanted := make(map[string]bool, len(built.entities))
+	added := policyItems{itemType: built.itemType}
+	for i, entity := range built.entities {
+		wanted[entity.ID] = true
+		ordinal, linked := current[entity.ID]
+		if !linked {
+			added.entities = append(added.entities, entity)
+			added.relations = append(added.relations, built.relations[i])
+			continue
+		}
+		if ordinal != i {
+			// The relation's primary key selects the row
+			if err := tx.Model(built.relations[i]).Update("ordinal", i).Error; err != nil {
+				return errors.WithStack(err)
+			}
+		}
+	}
+
+	removed := make([]string, 0, len(current))
+	for entityID := range current {
+		if !wanted[entityID] {
+			removed = append(removed, entityID)
+		}
+	}
+	sort.Strings(removed)
+
+	if err := strategy.DeleteRelations(policyID, removed, tx); err != nil {
+		return errors.WithStack(err)
+	}
+	if err := s.insertEntities([]policyItems{added}, tx); err != nil {
+		return err
+	}
+	// Linked entities may have been compiled with the delimiters the policy had before
+	if err := s.checkStoredEntities([]policyItems{built}, policyID, tx); err != nil {
+		return err
+	}
+	return s.persistRelations([]policyItems{added}, tx)
 }
 
 // Create inserts a new policy
 func (s *SQLManager) Create(ctx context.Context, policy ladon.Policy) error {
-	start := time.Now()
-	defer func() {
-		s.logSlowQuery("Create", time.Since(start))
-	}()
+	_, err := s.CreateWithReport(ctx, policy)
+	return err
+}
+
+// CreateWithReport inserts a new policy like Create and returns the templates left
+// out of the stored policy when Config.LenientTemplates is set
+func (s *SQLManager) CreateWithReport(ctx context.Context, policy ladon.Policy) (_ []*TemplateError, err error) {
+	ctx, op := s.startOperation(ctx, "Create")
+	defer func() { op.finish(err) }()
+	op.span.SetAttribute(AttributePolicyID, policy.GetID())
+
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	prepared, err := s.preparePolicy(policy)
+	if err != nil {
+		return nil, err
+	}
 
-	return s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
-		return s.create(policy, tx)
+	err = s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := s.insertEntities(prepared.items, tx); err != nil {
+			return err
+		}
+		if err := s.insertPolicy(prepared, tx); err != nil {
+			return err
+		}
+		return s.publishChange(tx, policy.GetID())
 	})
+	if err != nil {
+		return nil, timeoutError(err)
+	}
+	op.metrics.Rows = 1
+	return prepared.skipped, nil
+}
+
+// preparedPolicy is a validated policy model together with the entities and
+// relations built from its templates, ready to be persisted
+type preparedPolicy struct {
+	model *models.Policy
+	items []policyItems
+	// skipped lists the templates left out in lenient mode
+	skipped []*TemplateError
+}
+
+// policyItems holds the entities and relations built from the templates of one
+// entity type
+type policyItems struct {
+	itemType  string
+	entities  []models.BaseEntity
+	relations []interface{}
 }
 
-func (s *SQLManager) create(policy ladon.Policy, tx *gorm.DB) error {
+// preparePolicy validates a policy and builds everything needed to store it
+// without touching the database. A template that fails to compile rejects the
+// policy, unless Config.LenientTemplates is set.
+func (s *SQLManager) preparePolicy(policy ladon.Policy) (*preparedPolicy, error) {
+	policyModel, err := s.buildPolicyModel(policy)
+	if err != nil {
+		return nil, err
+	}
+
+	prepared := &preparedPolicy{model: policyModel}
+	templates := map[string][]string{
+		itemTypeSubject:  policy.GetSubjects(),
+		itemTypeAction:   policy.GetActions(),
+		itemTypeResource: policy.GetResources(),
+	}
+	for _, itemType := range []string{itemTypeSubject, itemTypeAction, itemTypeResource} {
+		items, skipped, err := s.buildPolicyItems(templates[itemType], itemType, policy.GetID(), policy.GetStartDelimiter(), policy.GetEndDelimiter())
+		if err != nil {
+			return nil, err
+		}
+		prepared.items = append(prepared.items, items)
+		prepared.skipped = append(prepared.skipped, skipped...)
+	}
+
+	for _, skipped := range prepared.skipped {
+		s.logger.Warn("Skipped invalid template",
+			"policy_id", skipped.PolicyID,
+			"type", skipped.Type,
+			"template", skipped.Template,
+			"error", skipped.Err,
+		)
+	}
+	return prepared, nil
+}
+
+// insertPolicy stores a prepared policy and its relations. The entities it refers
+// to must have been inserted already.
+func (s *SQLManager) insertPolicy(prepared *preparedPolicy, tx *gorm.DB) error {
+	// A soft-deleted policy still owns its ID, so replace it
+	if err := s.purgeDeleted(prepared.model.ID, tx); err != nil {
+		return err
+	}
+
+	if err := tx.Omit(clause.Associations).Create(prepared.model).Error; err != nil {
+		if s.isUniqueViolation(err) {
+			return errors.WithStack(ErrPolicyExists)
+		}
+		return errors.WithStack(err)
+	}
+
+	// Process subjects, actions, and resources
+	if err := s.checkStoredEntities(prepared.items, prepared.model.ID, tx); err != nil {
+		return err
+	}
+	return s.persistRelations(prepared.items, tx)
+}
+
+// buildPolicyModel converts a ladon policy into a validated policy model without
+// its relations
+func (s *SQLManager) buildPolicyModel(policy ladon.Policy) (*models.Policy, error) {
 	// Input validation
 	if policy.GetID() == "" {
-		return errors.WithStack(ErrEmptyPolicyID)
+		return nil, errors.WithStack(ErrEmptyPolicyID)
 	}
 	if len(policy.GetID()) > models.PolicyIDMaxLength {
-		return errors.WithStack(ErrPolicyIDTooLong)
+		return nil, errors.WithStack(ErrPolicyIDTooLong)
 	}
 
 	conditions := []byte("{}")
@@ -130,7 +461,11 @@ func (s *SQLManager) create(policy ladon.Policy, tx *gorm.DB) error {
 		var err error
 		conditions, err = json.Marshal(&cs)
 		if err != nil {
-			return errors.WithStack(err)
+			return nil, errors.WithStack(err)
+		}
+		// Conditions that could not be read back would be lost on the next read
+		if _, err := s.decodeConditions(policy.GetID(), conditions); err != nil {
+			return nil, err
 		}
 	}
 
@@ -139,88 +474,116 @@ func (s *SQLManager) create(policy ladon.Policy, tx *gorm.DB) error {
 		meta = policy.GetMeta()
 	}
 
-	// Create policy using GORM
+	// Build the policy model for GORM
 	policyModel := &models.Policy{
-		ID:          policy.GetID(),
-		Description: policy.GetDescription(),
-		Effect:      policy.GetEffect(),
-		Conditions:  models.JSONText(conditions),
-		Meta:        models.JSONText(meta),
+		ID:             policy.GetID(),
+		Description:    policy.GetDescription(),
+		Effect:         policy.GetEffect(),
+		Conditions:     models.JSONText(conditions),
+		Meta:           models.JSONText(meta),
+		StartDelimiter: policy.GetStartDelimiter(),
+		EndDelimiter:   policy.GetEndDelimiter(),
 	}
 
 	// Validate policy model before persisting
 	if err := policyModel.Validate(); err != nil {
-		return errors.WithStack(err)
-	}
-
-	if err := tx.Create(policyModel).Error; err != nil {
-		return errors.WithStack(err)
-	}
-
-	// Process subjects, actions, and resources
-	if err := s.processPolicyRelations(policy, tx); err != nil {
-		return errors.WithStack(err)
-	}
-
-	return nil
-}
-
-func (s *SQLManager) processPolicyRelations(policy ladon.Policy, tx *gorm.DB) error {
-	// Process subjects
-	if err := s.processPolicyItems(policy.GetSubjects(), itemTypeSubject, policy.GetID(), policy.GetStartDelimiter(), policy.GetEndDelimiter(), tx); err != nil {
-		return err
-	}
-
-	// Process actions
-	if err := s.processPolicyItems(policy.GetActions(), itemTypeAction, policy.GetID(), policy.GetStartDelimiter(), policy.GetEndDelimiter(), tx); err != nil {
-		return err
-	}
-
-	// Process resources
-	if err := s.processPolicyItems(policy.GetResources(), itemTypeResource, policy.GetID(), policy.GetStartDelimiter(), policy.GetEndDelimiter(), tx); err != nil {
-		return err
+		return nil, errors.WithStack(err)
 	}
 
-	return nil
+	return policyModel, nil
 }
 
-func (s *SQLManager) processPolicyItems(items []string, itemType string, policyID string, startDelim, endDelim byte, tx *gorm.DB) error {
+// buildPolicyItems builds the entities and relations for the templates of one
+// entity type. Templates listed twice, also once trimmed, are rejected with
+// ErrDuplicateTemplate. In lenient mode templates that fail to compile are
+// returned as skipped.
+func (s *SQLManager) buildPolicyItems(items []string, itemType string, policyID string, startDelim, endDelim byte) (policyItems, []*TemplateError, error) {
 	// Get the appropriate factory for this entity type
 	factory, exists := s.factoryRegistry.GetFactory(itemType)
 	if !exists {
-		return errors.Errorf("unsupported entity type: %s", itemType)
+		return policyItems{}, nil, errors.Errorf("unsupported entity type: %s", itemType)
 	}
 
-	// Batch process items for better performance
-	relationships := make([]interface{}, 0, len(items))
+	built := policyItems{
+		itemType:  itemType,
+		entities:  make([]models.BaseEntity, 0, len(items)),
+		relations: make([]interface{}, 0, len(items)),
+	}
+	seen := make(map[string]bool, len(items))
+	var skipped []*TemplateError
 
 	for _, template := range items {
 		// Use the builder to create the base entity
 		baseEntity, err := s.builderDirector.BuildStandardEntity(template, startDelim, endDelim)
 		if err != nil {
-			// Skip invalid templates but continue processing others
+			templateErr := &TemplateError{PolicyID: policyID, Type: itemType, Template: template, Err: errors.Cause(err)}
+			if !s.config.LenientTemplates {
+				return policyItems{}, nil, errors.WithStack(templateErr)
+			}
+			skipped = append(skipped, templateErr)
 			continue
 		}
 
-		// Use the factory to create the specific entity type and relationship
-		item := factory.CreateEntity(baseEntity)
-		relation := factory.CreateRelation(policyID, baseEntity.ID)
+		if seen[baseEntity.ID] {
+			return policyItems{}, nil, errors.WithStack(&TemplateError{PolicyID: policyID, Type: itemType, Template: template, Err: ErrDuplicateTemplate})
+		}
+		seen[baseEntity.ID] = true
 
-		// Use GORM's FirstOrCreate to avoid duplicates
-		if err := tx.Where("id = ?", baseEntity.ID).FirstOrCreate(item).Error; err != nil {
-			return errors.WithStack(err)
+		relation := factory.CreateRelation(policyID, baseEntity.ID)
+		if ordered, ok := relation.(models.OrderedRelation); ok {
+			ordered.SetOrdinal(len(built.entities))
 		}
+		built.entities = append(built.entities, baseEntity)
+		built.relations = append(built.relations, relation)
+	}
 
-		relationships = append(relationships, relation)
+	return built, skipped, nil
+}
+
+// insertEntities batch inserts the entities of all given items, grouped by entity
+// type and deduplicated, keeping the ones that already exist
+func (s *SQLManager) insertEntities(items []policyItems, tx *gorm.DB) error {
+	byType := make(map[string][]models.BaseEntity)
+	seen := make(map[string]bool)
+	order := make([]string, 0, 3)
+
+	for _, built := range items {
+		if _, ok := byType[built.itemType]; !ok {
+			order = append(order, built.itemType)
+		}
+		for _, entity := range built.entities {
+			key := built.itemType + ":" + entity.ID
+			if seen[key] {
+				continue
+			}
+			seen[key] = true
+			byType[built.itemType] = append(byType[built.itemType], entity)
+		}
 	}
 
-	// Batch create relationships
-	for _, rel := range relationships {
-		if err := s.createPolicyRelationOptimized(rel, tx); err != nil {
+	for _, itemType := range order {
+		factory, exists := s.factoryRegistry.GetFactory(itemType)
+		if !exists {
+			return errors.Errorf("unsupported entity type: %s", itemType)
+		}
+		if err := insertIgnoringDuplicates(tx, factory.CreateEntities(byType[itemType]), s.config.MaxBatchSize); err != nil {
 			return errors.WithStack(err)
 		}
 	}
+	return nil
+}
 
+// persistRelations batch inserts the relations of all given items
+func (s *SQLManager) persistRelations(items []policyItems, tx *gorm.DB) error {
+	for _, built := range items {
+		strategy, exists := s.strategyRegistry.GetStrategy(built.itemType)
+		if !exists {
+			return errors.Errorf("unsupported entity type: %s", built.itemType)
+		}
+		if err := strategy.PersistRelations(built.relations, s.config.MaxBatchSize, tx); err != nil {
+			return errors.WithStack(err)
+		}
+	}
 	return nil
 }
 
@@ -257,27 +620,137 @@ func (s *SQLManager) buildRegexQuery(query *gorm.DB, field string, value string)
 		return query.Where(fmt.Sprintf("(%s.has_regex = ? AND ? ~ %s.compiled) OR (%s.has_regex = ? AND %s.template = ?)", field, field, field, field),
 			true, value, false, value)
 	case "mysql":
-		return query.Where(fmt.Sprintf("(%s.has_regex = ? AND ? REGEXP BINARY %s.compiled) OR (%s.has_regex = ? AND %s.template = ?)", field, field, field, field),
+		// REGEXP BINARY is rejected for utf8mb4 columns since MySQL 8.0.22, so ask
+		// REGEXP_LIKE for a case-sensitive match instead
+		return query.Where(fmt.Sprintf("(%s.has_regex = ? AND REGEXP_LIKE(?, %s.compiled, 'c')) OR (%s.has_regex = ? AND %s.template = ?)", field, field, field, field),
 			true, value, false, value)
+	case "sqlite", "sqlite3":
+		// SQLite has no regex operator, so only narrow the rows down here and
+		// leave the regex matching to filterPolicies
+		return query.Where(fmt.Sprintf("(%s.has_regex = ?) OR (%s.template = ?)", field, field),
+			true, value)
 	default:
 		return query
 	}
 }
 
+// supportsDriver reports whether the configured driver name is known
+func (s *SQLManager) supportsDriver() bool {
+	switch s.driverName {
+	case "postgres", "pg", "pgx", "mysql", "sqlite", "sqlite3":
+		return true
+	default:
+		return false
+	}
+}
+
+// isSQLite reports whether candidate rows have to be matched in Go
+func (s *SQLManager) isSQLite() bool {
+	return s.driverName == "sqlite" || s.driverName == "sqlite3"
+}
+
+// filterPolicies keeps the policies with at least one entity matching value.
+// It completes buildRegexQuery for drivers that cannot match regexes in SQL.
+func (s *SQLManager) filterPolicies(policies []models.Policy, entities func(models.Policy) []models.BaseEntity, value string) ([]models.Policy, error) {
+	if !s.isSQLite() {
+		return policies, nil
+	}
+
+	filtered := policies[:0]
+	for _, policy := range policies {
+		matched, err := s.matcher.MatchesAny(entities(policy), value)
+		if err != nil {
+			return nil, err
+		}
+		if matched {
+			filtered = append(filtered, policy)
+		}
+	}
+	return filtered, nil
+}
+
 // sanitizeTemplate removes potentially dangerous characters from templates
 func sanitizeTemplate(template string) string {
 	return strings.TrimSpace(template)
 }
 
-// logSlowQuery logs queries that exceed the slow query threshold
-func (s *SQLManager) logSlowQuery(operation string, duration time.Duration) {
-	if s.config.EnableMetrics && duration > s.config.SlowQueryThreshold {
-		log.Printf("[SLOW QUERY] %s took %v", operation, duration)
+// operation tracks a single SQLManager call for metrics, tracing and slow query logging
+type operation struct {
+	manager *SQLManager
+	ctx     context.Context
+	start   time.Time
+	span    Span
+	metrics OperationMetrics
+}
+
+// startOperation starts tracking a SQLManager call and returns the context to run
+// its queries with. The caller fills in the row and candidate counts and calls
+// finish when it returns.
+func (s *SQLManager) startOperation(ctx context.Context, name string) (context.Context, *operation) {
+	ctx, span := s.startSpan(ctx, name)
+	return ctx, &operation{
+		manager: s,
+		ctx:     ctx,
+		start:   time.Now(),
+		span:    span,
+		metrics: OperationMetrics{Operation: name},
+	}
+}
+
+// finish reports the call to the metrics recorder and ends its span
+func (o *operation) finish(err error) {
+	o.metrics.Duration = time.Since(o.start)
+	o.metrics.Err = err
+	o.manager.logOperation(o.ctx, o.metrics)
+	if o.manager.config.Metrics != nil {
+		o.manager.config.Metrics.RecordOperation(o.metrics)
+	}
+
+	o.span.SetAttribute(AttributeRows, o.metrics.Rows)
+	if strings.HasPrefix(o.metrics.Operation, "Find") {
+		o.span.SetAttribute(AttributeCandidates, o.metrics.Candidates)
+	}
+	if err != nil {
+		o.span.RecordError(err)
+	}
+	o.span.End()
+}
+
+// logOperation logs every call at debug level and calls that exceed the slow query
+// threshold as warnings
+func (s *SQLManager) logOperation(ctx context.Context, metrics OperationMetrics) {
+	attrs := []slog.Attr{
+		slog.String("operation", metrics.Operation),
+		slog.Duration("duration", metrics.Duration),
+		slog.Int("rows", metrics.Rows),
+	}
+	if metrics.Err != nil {
+		attrs = append(attrs, slog.Any("error", metrics.Err))
+	}
+
+	if s.config.EnableMetrics && metrics.Duration > s.config.SlowQueryThreshold {
+		attrs = append(attrs, slog.Duration("threshold", s.config.SlowQueryThreshold))
+		s.logger.LogAttrs(ctx, slog.LevelWarn, "Slow query", attrs...)
+		return
 	}
+	s.logger.LogAttrs(ctx, slog.LevelDebug, "Operation finished", attrs...)
 }
 
 // FindRequestCandidates returns policies that potentially match a ladon.Request
-func (s *SQLManager) FindRequestCandidates(ctx context.Context, r *ladon.Request) (ladon.Policies, error) {
+func (s *SQLManager) FindRequestCandidates(ctx context.Context, r *ladon.Request) (_ ladon.Policies, err error) {
+	ctx, op := s.startOperation(ctx, "FindRequestCandidates")
+	defer func() { op.finish(err) }()
+	op.span.SetAttribute(AttributeRequestSubject, r.Subject)
+	op.span.SetAttribute(AttributeRequestAction, r.Action)
+	op.span.SetAttribute(AttributeRequestResource, r.Resource)
+
+	if !s.supportsDriver() {
+		return nil, ErrInvalidDriver
+	}
+
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
 	var policies []models.Policy
 
 	// Use GORM to find policies with matching subjects
@@ -290,34 +763,60 @@ func (s *SQLManager) FindRequestCandidates(ctx context.Context, r *ladon.Request
 		Joins(fmt.Sprintf("JOIN %s s ON s.id = psr.subject", models.TableNameSubject))
 
 	// Database-specific regex handling
-	switch s.driverName {
-	case "postgres", "pg", "pgx":
-		query = query.Where("(s.has_regex = ? AND ? ~ s.compiled) OR (s.has_regex = ? AND s.template = ?)",
-			true, r.Subject, false, r.Subject)
-	case "mysql":
-		query = query.Where("(s.has_regex = ? AND ? REGEXP BINARY s.compiled) OR (s.has_regex = ? AND s.template = ?)",
-			true, r.Subject, false, r.Subject)
-	default:
-		return nil, ErrInvalidDriver
+	query = s.buildRegexQuery(query, "s", r.Subject)
+
+	// In strict mode only return policies whose actions and resources match too
+	if s.config.StrictCandidates {
+		query = query.
+			Joins(fmt.Sprintf("JOIN %s par ON par.policy = %s.id", models.TableNamePolicyActionRel, models.TableNamePolicy)).
+			Joins(fmt.Sprintf("JOIN %s a ON a.id = par.action", models.TableNameAction)).
+			Joins(fmt.Sprintf("JOIN %s prr ON prr.policy = %s.id", models.TableNamePolicyResourceRel, models.TableNamePolicy)).
+			Joins(fmt.Sprintf("JOIN %s r ON r.id = prr.resource", models.TableNameResource))
+		query = s.buildRegexQuery(query, "a", r.Action)
+		query = s.buildRegexQuery(query, "r", r.Resource)
 	}
 
-	err := query.Find(&policies).Error
+	err = query.Find(&policies).Error
 
 	if err != nil {
 		if errors.Is(err, gorm.ErrRecordNotFound) {
 			return nil, ladon.NewErrResourceNotFound(err)
 		}
-		return nil, errors.WithStack(err)
+		return nil, timeoutError(errors.WithStack(err))
+	}
+	op.metrics.Rows = len(policies)
+
+	policies, err = s.filterPolicies(policies, subjectEntities, r.Subject)
+	if err != nil {
+		return nil, err
 	}
+	if s.config.StrictCandidates {
+		if policies, err = s.filterPolicies(policies, actionEntities, r.Action); err != nil {
+			return nil, err
+		}
+		if policies, err = s.filterPolicies(policies, resourceEntities, r.Resource); err != nil {
+			return nil, err
+		}
+	}
+	op.metrics.Candidates = len(policies)
 
-	return s.convertPoliciesToLadon(policies), nil
+	if err = s.sortPolicyItems(s.db.WithContext(ctx), policies); err != nil {
+		return nil, timeoutError(err)
+	}
+	return s.convertPoliciesToLadon(policies)
 }
 
 // GetAll returns all policies
-func (s *SQLManager) GetAll(ctx context.Context, limit, offset int64) (ladon.Policies, error) {
+func (s *SQLManager) GetAll(ctx context.Context, limit, offset int64) (_ ladon.Policies, err error) {
+	ctx, op := s.startOperation(ctx, "GetAll")
+	defer func() { op.finish(err) }()
+
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
 	var policies []models.Policy
 
-	err := s.db.WithContext(ctx).
+	err = s.db.WithContext(ctx).
 		Preload("Subjects").
 		Preload("Actions").
 		Preload("Resources").
@@ -327,22 +826,63 @@ func (s *SQLManager) GetAll(ctx context.Context, limit, offset int64) (ladon.Pol
 		Find(&policies).Error
 
 	if err != nil {
-		return nil, errors.WithStack(err)
+		return nil, timeoutError(errors.WithStack(err))
+	}
+
+	op.metrics.Rows = len(policies)
+
+	if err = s.sortPolicyItems(s.db.WithContext(ctx), policies); err != nil {
+		return nil, timeoutError(err)
+	}
+	return s.convertPoliciesToLadon(policies)
+}
+
+// findPolicyModels loads the policies with the given IDs, or all policies when ids
+// is nil, together with their entities
+func (s *SQLManager) findPolicyModels(ctx context.Context, ids []string) (_ []models.Policy, err error) {
+	ctx, op := s.startOperation(ctx, "LoadPolicies")
+	defer func() { op.finish(err) }()
+
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	query := s.db.WithContext(ctx).
+		Preload("Subjects").
+		Preload("Actions").
+		Preload("Resources").
+		Order("id")
+	if ids != nil {
+		query = query.Where("id IN ?", ids)
+	}
+
+	var policies []models.Policy
+	err = query.Find(&policies).Error
+	if err != nil {
+		return nil, timeoutError(errors.WithStack(err))
 	}
+	op.metrics.Rows = len(policies)
 
-	return s.convertPoliciesToLadon(policies), nil
+	// Load the ordinals of all policies without listing their IDs
+	ordinals, err := s.loadOrdinals(s.db.WithContext(ctx), ids)
+	if err != nil {
+		return nil, timeoutError(err)
+	}
+	orderPolicyItems(policies, ordinals)
+	return policies, nil
 }
 
 // Get retrieves a policy.
-func (s *SQLManager) Get(ctx context.Context, id string) (ladon.Policy, error) {
-	start := time.Now()
-	defer func() {
-		s.logSlowQuery("Get", time.Since(start))
-	}()
+func (s *SQLManager) Get(ctx context.Context, id string) (_ ladon.Policy, err error) {
+	ctx, op := s.startOperation(ctx, "Get")
+	defer func() { op.finish(err) }()
+	op.span.SetAttribute(AttributePolicyID, id)
+
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
 
 	var policy models.Policy
 
-	err := s.db.WithContext(ctx).
+	err = s.db.WithContext(ctx).
 		Preload("Subjects").
 		Preload("Actions").
 		Preload("Resources").
@@ -351,33 +891,107 @@ func (s *SQLManager) Get(ctx context.Context, id string) (ladon.Policy, error) {
 
 	if err != nil {
 		if errors.Is(err, gorm.ErrRecordNotFound) {
-			return nil, ladon.NewErrResourceNotFound(err)
+			return nil, errors.WithStack(ErrPolicyNotFound)
 		}
-		return nil, errors.WithStack(err)
+		return nil, timeoutError(errors.WithStack(err))
 	}
 
-	return s.convertPolicyToLadon(policy), nil
+	op.metrics.Rows = 1
+
+	policies := []models.Policy{policy}
+	if err = s.sortPolicyItems(s.db.WithContext(ctx), policies); err != nil {
+		return nil, timeoutError(err)
+	}
+	return s.convertPolicyToLadon(policies[0])
 }
 
 // Delete removes a policy.
-func (s *SQLManager) Delete(ctx context.Context, id string) error {
-	return s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
-		return s.delete(id, tx)
+func (s *SQLManager) Delete(ctx context.Context, id string) (err error) {
+	ctx, op := s.startOperation(ctx, "Delete")
+	defer func() { op.finish(err) }()
+	op.span.SetAttribute(AttributePolicyID, id)
+
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	var deleted int64
+	err = s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		var err error
+		if deleted, err = s.delete(id, tx); err != nil {
+			return err
+		}
+		if deleted == 0 {
+			return errors.WithStack(ErrPolicyNotFound)
+		}
+		return s.publishChange(tx, id)
 	})
+	if err != nil {
+		return timeoutError(err)
+	}
+	op.metrics.Rows = int(deleted)
+	return nil
 }
 
-// Delete removes a policy.
-func (s *SQLManager) delete(id string, tx *gorm.DB) error {
-	// GORM will handle cascade deletes due to foreign key constraints
-	return tx.Delete(&models.Policy{}, "id = ?", id).Error
+// delete removes a policy according to the configured DeleteMode and returns the
+// number of policies removed.
+func (s *SQLManager) delete(id string, tx *gorm.DB) (int64, error) {
+	if s.config.DeleteMode == HardDelete {
+		return s.purge(id, tx)
+	}
+	// Soft deletes keep the relation rows so the policy can be restored
+	result := tx.Delete(&models.Policy{}, "id = ?", id)
+	return result.RowsAffected, errors.WithStack(result.Error)
+}
+
+// purge permanently removes a policy, deleted or not, and its relation rows.
+// The relation rows are removed explicitly rather than through ON DELETE CASCADE
+// because SQLite only enforces foreign keys when they are enabled per connection.
+func (s *SQLManager) purge(id string, tx *gorm.DB) (int64, error) {
+	relations := []interface{}{
+		&models.PolicySubjectRel{},
+		&models.PolicyActionRel{},
+		&models.PolicyResourceRel{},
+	}
+	for _, relation := range relations {
+		if err := tx.Where("policy = ?", id).Delete(relation).Error; err != nil {
+			return 0, errors.WithStack(err)
+		}
+	}
+	result := tx.Unscoped().Delete(&models.Policy{}, "id = ?", id)
+	return result.RowsAffected, errors.WithStack(result.Error)
+}
+
+// isDeleted reports whether the policy with the given ID is soft-deleted
+func (s *SQLManager) isDeleted(id string, tx *gorm.DB) (bool, error) {
+	var count int64
+	err := tx.Unscoped().
+		Model(&models.Policy{}).
+		Where("id = ? AND deleted_at IS NOT NULL", id).
+		Count(&count).Error
+	if err != nil {
+		return false, errors.WithStack(err)
+	}
+	return count > 0, nil
+}
+
+// purgeDeleted purges the policy with the given ID if it is soft-deleted
+func (s *SQLManager) purgeDeleted(id string, tx *gorm.DB) error {
+	deleted, err := s.isDeleted(id, tx)
+	if err != nil || !deleted {
+		return err
+	}
+	_, err = s.purge(id, tx)
+	return err
 }
 
 // FindPoliciesForSubject returns policies that could match the subject.
-func (s *SQLManager) FindPoliciesForSubject(ctx context.Context, subject string) (ladon.Policies, error) {
-	start := time.Now()
-	defer func() {
-		s.logSlowQuery("FindPoliciesForSubject", time.Since(start))
-	}()
+func (s *SQLManager) FindPoliciesForSubject(ctx context.Context, subject string) (_ ladon.Policies, err error) {
+	ctx, op := s.startOperation(ctx, "FindPoliciesForSubject")
+	defer func() { op.finish(err) }()
+	op.span.SetAttribute(AttributeRequestSubject, subject)
+
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
 
 	var policies []models.Policy
 
@@ -391,28 +1005,40 @@ func (s *SQLManager) FindPoliciesForSubject(ctx context.Context, subject string)
 
 	// Use the helper method for database-specific regex handling
 	query = s.buildRegexQuery(query, "s", subject)
-	if s.driverName != "postgres" && s.driverName != "pg" && s.driverName != "pgx" && s.driverName != "mysql" {
+	if !s.supportsDriver() {
 		return nil, ErrInvalidDriver
 	}
 
-	err := query.Find(&policies).Error
+	err = query.Find(&policies).Error
 
 	if err != nil {
 		if errors.Is(err, gorm.ErrRecordNotFound) {
 			return nil, ladon.NewErrResourceNotFound(err)
 		}
-		return nil, errors.WithStack(err)
+		return nil, timeoutError(errors.WithStack(err))
+	}
+	op.metrics.Rows = len(policies)
+
+	policies, err = s.filterPolicies(policies, subjectEntities, subject)
+	if err != nil {
+		return nil, err
 	}
+	op.metrics.Candidates = len(policies)
 
-	return s.convertPoliciesToLadon(policies), nil
+	if err = s.sortPolicyItems(s.db.WithContext(ctx), policies); err != nil {
+		return nil, timeoutError(err)
+	}
+	return s.convertPoliciesToLadon(policies)
 }
 
 // FindPoliciesForResource returns policies that could match the resource.
-func (s *SQLManager) FindPoliciesForResource(ctx context.Context, resource string) (ladon.Policies, error) {
-	start := time.Now()
-	defer func() {
-		s.logSlowQuery("FindPoliciesForResource", time.Since(start))
-	}()
+func (s *SQLManager) FindPoliciesForResource(ctx context.Context, resource string) (_ ladon.Policies, err error) {
+	ctx, op := s.startOperation(ctx, "FindPoliciesForResource")
+	defer func() { op.finish(err) }()
+	op.span.SetAttribute(AttributeRequestResource, resource)
+
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
 
 	var policies []models.Policy
 
@@ -426,29 +1052,91 @@ func (s *SQLManager) FindPoliciesForResource(ctx context.Context, resource strin
 
 	// Use the helper method for database-specific regex handling
 	query = s.buildRegexQuery(query, "r", resource)
-	if s.driverName != "postgres" && s.driverName != "pg" && s.driverName != "pgx" && s.driverName != "mysql" {
+	if !s.supportsDriver() {
 		return nil, ErrInvalidDriver
 	}
 
-	err := query.Find(&policies).Error
+	err = query.Find(&policies).Error
 
 	if err != nil {
 		if errors.Is(err, gorm.ErrRecordNotFound) {
 			return nil, ladon.NewErrResourceNotFound(err)
 		}
-		return nil, errors.WithStack(err)
+		return nil, timeoutError(errors.WithStack(err))
+	}
+	op.metrics.Rows = len(policies)
+
+	policies, err = s.filterPolicies(policies, resourceEntities, resource)
+	if err != nil {
+		return nil, err
+	}
+	op.metrics.Candidates = len(policies)
+
+	if err = s.sortPolicyItems(s.db.WithContext(ctx), policies); err != nil {
+		return nil, timeoutError(err)
+	}
+	return s.convertPoliciesToLadon(policies)
+}
+
+// FindPoliciesForAction returns policies that could match the action.
+func (s *SQLManager) FindPoliciesForAction(ctx context.Context, action string) (_ ladon.Policies, err error) {
+	ctx, op := s.startOperation(ctx, "FindPoliciesForAction")
+	defer func() { op.finish(err) }()
+	op.span.SetAttribute(AttributeRequestAction, action)
+
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	var policies []models.Policy
+
+	query := s.db.WithContext(ctx).
+		Preload("Subjects").
+		Preload("Actions").
+		Preload("Resources").
+		Distinct().
+		Joins(fmt.Sprintf("JOIN %s par ON par.policy = %s.id", models.TableNamePolicyActionRel, models.TableNamePolicy)).
+		Joins(fmt.Sprintf("JOIN %s a ON a.id = par.action", models.TableNameAction))
+
+	// Use the helper method for database-specific regex handling
+	query = s.buildRegexQuery(query, "a", action)
+	if !s.supportsDriver() {
+		return nil, ErrInvalidDriver
+	}
+
+	err = query.Find(&policies).Error
+
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ladon.NewErrResourceNotFound(err)
+		}
+		return nil, timeoutError(errors.WithStack(err))
+	}
+	op.metrics.Rows = len(policies)
+
+	policies, err = s.filterPolicies(policies, actionEntities, action)
+	if err != nil {
+		return nil, err
 	}
+	op.metrics.Candidates = len(policies)
 
-	return s.convertPoliciesToLadon(policies), nil
+	if err = s.sortPolicyItems(s.db.WithContext(ctx), policies); err != nil {
+		return nil, timeoutError(err)
+	}
+	return s.convertPoliciesToLadon(policies)
 }
 
 // Helper functions to convert between GORM models and Ladon interfaces
-func (s *SQLManager) convertPolicyToLadon(policy models.Policy) ladon.Policy {
+func (s *SQLManager) convertPolicyToLadon(policy models.Policy) (ladon.Policy, error) {
+	conditions, err := s.decodeConditions(policy.ID, policy.Conditions)
+	if err != nil {
+		return nil, err
+	}
+
 	ladonPolicy := &ladon.DefaultPolicy{
 		ID:          policy.ID,
 		Description: policy.Description,
 		Effect:      policy.Effect,
-		Conditions:  ladon.Conditions{},
+		Conditions:  conditions,
 		Meta:        []byte(policy.Meta),
 	}
 
@@ -467,18 +1155,25 @@ func (s *SQLManager) convertPolicyToLadon(policy models.Policy) ladon.Policy {
 		ladonPolicy.Resources = append(ladonPolicy.Resources, resource.Template)
 	}
 
-	// Parse conditions
-	if len(policy.Conditions) > 0 {
-		_ = json.Unmarshal([]byte(policy.Conditions), &ladonPolicy.Conditions)
+	// ladon.DefaultPolicy always reports the default delimiters
+	if policy.StartDelimiter != models.DefaultStartDelimiter || policy.EndDelimiter != models.DefaultEndDelimiter {
+		return &DelimitedPolicy{
+			DefaultPolicy:  *ladonPolicy,
+			StartDelimiter: policy.StartDelimiter,
+			EndDelimiter:   policy.EndDelimiter,
+		}, nil
 	}
-
-	return ladonPolicy
+	return ladonPolicy, nil
 }
 
-func (s *SQLManager) convertPoliciesToLadon(policies []models.Policy) ladon.Policies {
+func (s *SQLManager) convertPoliciesToLadon(policies []models.Policy) (ladon.Policies, error) {
 	result := make(ladon.Policies, len(policies))
 	for i, policy := range policies {
-		result[i] = s.convertPolicyToLadon(policy)
+		converted, err := s.convertPolicyToLadon(policy)
+		if err != nil {
+			return nil, err
+		}
+		result[i] = converted
 	}
-	return result
+	return result, nil
 }
@@ -0,0 +1,854 @@
+package ladonsqlmanager
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ladonsqlmanager/models"
+	"github.com/ory/ladon"
+	"gorm.io/driver/mysql"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// newTestManager returns a manager backed by a fresh in-memory SQLite database
+func newTestManager(t *testing.T) *SQLManager {
+	t.Helper()
+	return newTestManagerWithConfig(t, DefaultConfig())
+}
+
+// newTestManagerWithConfig returns a manager with the given configuration backed by
+// a fresh in-memory SQLite database
+func newTestManagerWithConfig(t *testing.T, config Config) *SQLManager {
+	t.Helper()
+
+	manager := NewWithConfig(newTestDB(t), "sqlite", config)
+	if err := manager.Init(); err != nil {
+		t.Fatalf("Failed to initialize manager: %v", err)
+	}
+	return manager
+}
+
+// newTestDB returns a fresh, empty in-memory SQLite database
+func newTestDB(t *testing.T) *gorm.DB {
+	t.Helper()
+
+	db, err := gorm.Open(sqlite.Open("file::memory:?_foreign_keys=on"), &gorm.Config{
+		Logger: logger.Default.LogMode(logger.Silent),
+	})
+	if err != nil {
+		t.Fatalf("Failed to open database: %v", err)
+	}
+
+	// Every connection to :memory: gets its own database, so pin the pool to one
+	sqlDB, err := db.DB()
+	if err != nil {
+		t.Fatalf("Failed to get database handle: %v", err)
+	}
+	sqlDB.SetMaxOpenConns(1)
+	t.Cleanup(func() { sqlDB.Close() })
+	return db
+}
+
+// createTestPolicies stores the given policies and fails the test on error
+func createTestPolicies(t *testing.T, manager *SQLManager, policies ...ladon.Policy) {
+	t.Helper()
+	for _, policy := range policies {
+		if err := manager.Create(context.Background(), policy); err != nil {
+			t.Fatalf("Failed to create policy '%s': %v", policy.GetID(), err)
+		}
+	}
+}
+
+// policyIDs returns the IDs of the given policies in order
+func policyIDs(policies ladon.Policies) []string {
+	ids := make([]string, len(policies))
+	for i, policy := range policies {
+		ids[i] = policy.GetID()
+	}
+	return ids
+}
+
+// containsID reports whether the policies include one with the given ID
+func containsID(policies ladon.Policies, id string) bool {
+	for _, policy := range policies {
+		if policy.GetID() == id {
+			return true
+		}
+	}
+	return false
+}
+
+func TestSQLiteFindRequestCandidates(t *testing.T) {
+	manager := newTestManager(t)
+	ctx := context.Background()
+
+	createTestPolicies(t, manager,
+		&ladon.DefaultPolicy{
+			ID:          "exact",
+			Description: "exact subject",
+			Subjects:    []string{"user"},
+			Effect:      ladon.AllowAccess,
+			Resources:   []string{"article:1"},
+			Actions:     []string{"read"},
+		},
+		&ladon.DefaultPolicy{
+			ID:          "regex",
+			Description: "regex subject",
+			Subjects:    []string{"user:<[0-9]+>"},
+			Effect:      ladon.AllowAccess,
+			Resources:   []string{"article:<.*>"},
+			Actions:     []string{"read"},
+		},
+		&ladon.DefaultPolicy{
+			ID:          "other",
+			Description: "unrelated subject",
+			Subjects:    []string{"admin"},
+			Effect:      ladon.DenyAccess,
+			Resources:   []string{"article:1"},
+			Actions:     []string{"delete"},
+		},
+	)
+
+	tests := []struct {
+		subject  string
+		expected []string
+	}{
+		{subject: "user", expected: []string{"exact"}},
+		{subject: "user:42", expected: []string{"regex"}},
+		{subject: "user:abc", expected: []string{}},
+		{subject: "admin", expected: []string{"other"}},
+	}
+
+	for _, tt := range tests {
+		policies, err := manager.FindRequestCandidates(ctx, &ladon.Request{Subject: tt.subject})
+		if err != nil {
+			t.Fatalf("FindRequestCandidates(%q) failed: %v", tt.subject, err)
+		}
+		if len(policies) != len(tt.expected) {
+			t.Errorf("Expected %v for subject '%s', got %v", tt.expected, tt.subject, policyIDs(policies))
+			continue
+		}
+		for _, id := range tt.expected {
+			if !containsID(policies, id) {
+				t.Errorf("Expected policy '%s' for subject '%s', got %v", id, tt.subject, policyIDs(policies))
+			}
+		}
+	}
+}
+
+func TestSQLiteFindPoliciesForResource(t *testing.T) {
+	manager := newTestManager(t)
+	ctx := context.Background()
+
+	createTestPolicies(t, manager,
+		&ladon.DefaultPolicy{
+			ID:          "articles",
+			Description: "all articles",
+			Subjects:    []string{"user"},
+			Effect:      ladon.AllowAccess,
+			Resources:   []string{"article:<.*>"},
+			Actions:     []string{"read"},
+		},
+		&ladon.DefaultPolicy{
+			ID:          "files",
+			Description: "one file",
+			Subjects:    []string{"user"},
+			Effect:      ladon.AllowAccess,
+			Resources:   []string{"file:1"},
+			Actions:     []string{"read"},
+		},
+	)
+
+	policies, err := manager.FindPoliciesForResource(ctx, "article:7")
+	if err != nil {
+		t.Fatalf("FindPoliciesForResource failed: %v", err)
+	}
+	if len(policies) != 1 || policies[0].GetID() != "articles" {
+		t.Errorf("Expected [articles], got %v", policyIDs(policies))
+	}
+
+	policies, err = manager.FindPoliciesForSubject(ctx, "user")
+	if err != nil {
+		t.Fatalf("FindPoliciesForSubject failed: %v", err)
+	}
+	if len(policies) != 2 {
+		t.Errorf("Expected 2 policies for subject 'user', got %v", policyIDs(policies))
+	}
+}
+
+func TestSQLiteFindPoliciesForAction(t *testing.T) {
+	manager := newTestManager(t)
+	ctx := context.Background()
+
+	createTestPolicies(t, manager,
+		&ladon.DefaultPolicy{
+			ID:          "editors",
+			Description: "editors manage articles",
+			Subjects:    []string{"editor"},
+			Effect:      ladon.AllowAccess,
+			Resources:   []string{"article:<.*>"},
+			Actions:     []string{"<create|update|delete>"},
+		},
+		&ladon.DefaultPolicy{
+			ID:          "admins",
+			Description: "admins delete users",
+			Subjects:    []string{"admin"},
+			Effect:      ladon.AllowAccess,
+			Resources:   []string{"user:<.*>"},
+			Actions:     []string{"delete"},
+		},
+		&ladon.DefaultPolicy{
+			ID:          "readers",
+			Description: "readers read articles",
+			Subjects:    []string{"reader"},
+			Effect:      ladon.AllowAccess,
+			Resources:   []string{"article:<.*>"},
+			Actions:     []string{"read"},
+		},
+	)
+
+	policies, err := manager.FindPoliciesForAction(ctx, "delete")
+	if err != nil {
+		t.Fatalf("FindPoliciesForAction failed: %v", err)
+	}
+	if len(policies) != 2 || !containsID(policies, "editors") || !containsID(policies, "admins") {
+		t.Errorf("Expected [editors admins], got %v", policyIDs(policies))
+	}
+
+	policies, err = manager.FindPoliciesForAction(ctx, "read")
+	if err != nil {
+		t.Fatalf("FindPoliciesForAction failed: %v", err)
+	}
+	if len(policies) != 1 || policies[0].GetID() != "readers" {
+		t.Errorf("Expected [readers], got %v", policyIDs(policies))
+	}
+}
+
+func TestSQLiteWardenIsAllowed(t *testing.T) {
+	manager := newTestManager(t)
+	ctx := context.Background()
+
+	createTestPolicies(t, manager, &ladon.DefaultPolicy{
+		ID:          "1",
+		Description: "users can read articles",
+		Subjects:    []string{"user:<[0-9]+>"},
+		Effect:      ladon.AllowAccess,
+		Resources:   []string{"article:<[0-9]+>"},
+		Actions:     []string{"read"},
+	})
+
+	warden := &ladon.Ladon{Manager: manager}
+
+	if err := warden.IsAllowed(ctx, &ladon.Request{Subject: "user:1", Resource: "article:2", Action: "read"}); err != nil {
+		t.Errorf("Expected request to be allowed, got %v", err)
+	}
+	if err := warden.IsAllowed(ctx, &ladon.Request{Subject: "user:1", Resource: "article:2", Action: "write"}); err == nil {
+		t.Error("Expected request with unknown action to be denied")
+	}
+}
+
+func TestSQLiteStrictCandidates(t *testing.T) {
+	config := DefaultConfig()
+	config.StrictCandidates = true
+	manager := newTestManagerWithConfig(t, config)
+	ctx := context.Background()
+
+	createTestPolicies(t, manager,
+		&ladon.DefaultPolicy{
+			ID:          "read-articles",
+			Description: "read articles",
+			Subjects:    []string{"user"},
+			Effect:      ladon.AllowAccess,
+			Resources:   []string{"article:<[0-9]+>"},
+			Actions:     []string{"read"},
+		},
+		&ladon.DefaultPolicy{
+			ID:          "write-articles",
+			Description: "write articles",
+			Subjects:    []string{"user"},
+			Effect:      ladon.AllowAccess,
+			Resources:   []string{"article:<[0-9]+>"},
+			Actions:     []string{"<create|update>"},
+		},
+		&ladon.DefaultPolicy{
+			ID:          "read-files",
+			Description: "read files",
+			Subjects:    []string{"user"},
+			Effect:      ladon.AllowAccess,
+			Resources:   []string{"file:<.*>", "article:1"},
+			Actions:     []string{"read"},
+		},
+	)
+
+	tests := []struct {
+		request  *ladon.Request
+		expected []string
+	}{
+		{request: &ladon.Request{Subject: "user", Action: "read", Resource: "article:2"}, expected: []string{"read-articles"}},
+		{request: &ladon.Request{Subject: "user", Action: "read", Resource: "article:1"}, expected: []string{"read-articles", "read-files"}},
+		{request: &ladon.Request{Subject: "user", Action: "update", Resource: "article:1"}, expected: []string{"write-articles"}},
+		{request: &ladon.Request{Subject: "user", Action: "delete", Resource: "article:1"}, expected: []string{}},
+		{request: &ladon.Request{Subject: "admin", Action: "read", Resource: "article:1"}, expected: []string{}},
+	}
+
+	for _, tt := range tests {
+		policies, err := manager.FindRequestCandidates(ctx, tt.request)
+		if err != nil {
+			t.Fatalf("FindRequestCandidates(%+v) failed: %v", tt.request, err)
+		}
+		if len(policies) != len(tt.expected) {
+			t.Errorf("Expected %v for %+v, got %v", tt.expected, tt.request, policyIDs(policies))
+			continue
+		}
+		for _, id := range tt.expected {
+			if !containsID(policies, id) {
+				t.Errorf("Expected policy '%s' for %+v, got %v", id, tt.request, policyIDs(policies))
+			}
+		}
+	}
+}
+
+// countRelations returns the number of subject, action and resource relation rows of a policy
+func countRelations(t *testing.T, manager *SQLManager, id string) int64 {
+	t.Helper()
+
+	var total int64
+	for _, relation := range []interface{}{&models.PolicySubjectRel{}, &models.PolicyActionRel{}, &models.PolicyResourceRel{}} {
+		var count int64
+		if err := manager.db.Model(relation).Where("policy = ?", id).Count(&count).Error; err != nil {
+			t.Fatalf("Failed to count relations: %v", err)
+		}
+		total += count
+	}
+	return total
+}
+
+func TestSQLiteDeleteModes(t *testing.T) {
+	policy := &ladon.DefaultPolicy{
+		ID:          "1",
+		Description: "users can read articles",
+		Subjects:    []string{"user"},
+		Effect:      ladon.AllowAccess,
+		Resources:   []string{"article:1"},
+		Actions:     []string{"read"},
+	}
+
+	tests := []struct {
+		mode              DeleteMode
+		expectedRelations int64
+		expectedRows      int64
+	}{
+		{mode: SoftDelete, expectedRelations: 3, expectedRows: 1},
+		{mode: HardDelete, expectedRelations: 0, expectedRows: 0},
+	}
+
+	for _, tt := range tests {
+		config := DefaultConfig()
+		config.DeleteMode = tt.mode
+		manager := newTestManagerWithConfig(t, config)
+		ctx := context.Background()
+
+		createTestPolicies(t, manager, policy)
+		if err := manager.Delete(ctx, policy.ID); err != nil {
+			t.Fatalf("Delete failed: %v", err)
+		}
+
+		if _, err := manager.Get(ctx, policy.ID); err == nil {
+			t.Errorf("Expected deleted policy to be hidden in mode %d", tt.mode)
+		}
+
+		var rows int64
+		manager.db.Unscoped().Model(&models.Policy{}).Where("id = ?", policy.ID).Count(&rows)
+		if rows != tt.expectedRows {
+			t.Errorf("Expected %d policy rows in mode %d, got %d", tt.expectedRows, tt.mode, rows)
+		}
+		if relations := countRelations(t, manager, policy.ID); relations != tt.expectedRelations {
+			t.Errorf("Expected %d relation rows in mode %d, got %d", tt.expectedRelations, tt.mode, relations)
+		}
+
+		// Re-creating a deleted policy must not collide with the old row
+		createTestPolicies(t, manager, policy)
+		if _, err := manager.Get(ctx, policy.ID); err != nil {
+			t.Errorf("Expected re-created policy in mode %d, got %v", tt.mode, err)
+		}
+	}
+}
+
+func TestSQLiteUpdate(t *testing.T) {
+	for _, mode := range []DeleteMode{SoftDelete, HardDelete} {
+		config := DefaultConfig()
+		config.DeleteMode = mode
+		manager := newTestManagerWithConfig(t, config)
+		ctx := context.Background()
+
+		createTestPolicies(t, manager, &ladon.DefaultPolicy{
+			ID:          "1",
+			Description: "users can read articles",
+			Subjects:    []string{"user"},
+			Effect:      ladon.AllowAccess,
+			Resources:   []string{"article:1"},
+			Actions:     []string{"read"},
+		})
+
+		updated := &ladon.DefaultPolicy{
+			ID:          "1",
+			Description: "users can write articles",
+			Subjects:    []string{"user"},
+			Effect:      ladon.AllowAccess,
+			Resources:   []string{"article:1", "article:2"},
+			Actions:     []string{"write"},
+		}
+		if err := manager.Update(ctx, updated); err != nil {
+			t.Fatalf("Update failed in mode %d: %v", mode, err)
+		}
+
+		policy, err := manager.Get(ctx, "1")
+		if err != nil {
+			t.Fatalf("Get failed in mode %d: %v", mode, err)
+		}
+		if policy.GetDescription() != updated.Description {
+			t.Errorf("Expected description '%s', got '%s'", updated.Description, policy.GetDescription())
+		}
+		if len(policy.GetActions()) != 1 || policy.GetActions()[0] != "write" {
+			t.Errorf("Expected actions [write], got %v", policy.GetActions())
+		}
+		if relations := countRelations(t, manager, "1"); relations != 4 {
+			t.Errorf("Expected 4 relation rows in mode %d, got %d", mode, relations)
+		}
+	}
+}
+
+func TestSQLiteUpdateAppliesDiff(t *testing.T) {
+	manager := newTestManager(t)
+	ctx := context.Background()
+
+	createTestPolicies(t, manager, &ladon.DefaultPolicy{
+		ID:          "1",
+		Description: "users can read articles",
+		Subjects:    []string{"user", "guest"},
+		Effect:      ladon.AllowAccess,
+		Resources:   []string{"article:1"},
+		Actions:     []string{"read"},
+	})
+
+	var before models.Policy
+	manager.db.First(&before, "id = ?", "1")
+	var keptBefore models.PolicySubjectRel
+	manager.db.First(&keptBefore, "policy = ? AND subject = ?", "1", subjectID(t, "user"))
+
+	time.Sleep(10 * time.Millisecond)
+
+	err := manager.Update(ctx, &ladon.DefaultPolicy{
+		ID:          "1",
+		Description: "users can read and write articles",
+		Subjects:    []string{"user", "editor"},
+		Effect:      ladon.AllowAccess,
+		Resources:   []string{"article:1"},
+		Actions:     []string{"read", "write"},
+	})
+	if err != nil {
+		t.Fatalf("Update failed: %v", err)
+	}
+
+	var after models.Policy
+	manager.db.First(&after, "id = ?", "1")
+	if !after.CreatedAt.Equal(before.CreatedAt) {
+		t.Errorf("Expected CreatedAt %v to be preserved, got %v", before.CreatedAt, after.CreatedAt)
+	}
+	if !after.UpdatedAt.After(before.UpdatedAt) {
+		t.Errorf("Expected UpdatedAt to be bumped past %v, got %v", before.UpdatedAt, after.UpdatedAt)
+	}
+	if after.Description != "users can read and write articles" {
+		t.Errorf("Expected updated description, got '%s'", after.Description)
+	}
+
+	// The unchanged subject keeps its original relation row
+	var keptAfter models.PolicySubjectRel
+	if err := manager.db.First(&keptAfter, "policy = ? AND subject = ?", "1", subjectID(t, "user")).Error; err != nil {
+		t.Fatalf("Expected relation for unchanged subject, got %v", err)
+	}
+	if !keptAfter.CreatedAt.Equal(keptBefore.CreatedAt) {
+		t.Errorf("Expected unchanged relation row to be kept, created at %v, got %v", keptBefore.CreatedAt, keptAfter.CreatedAt)
+	}
+
+	policy, err := manager.Get(ctx, "1")
+	if err != nil {
+		t.Fatalf("Get failed: %v", err)
+	}
+	subjects := map[string]bool{}
+	for _, subject := range policy.GetSubjects() {
+		subjects[subject] = true
+	}
+	if len(subjects) != 2 || !subjects["user"] || !subjects["editor"] {
+		t.Errorf("Expected subjects [user editor], got %v", policy.GetSubjects())
+	}
+	if len(policy.GetActions()) != 2 {
+		t.Errorf("Expected 2 actions, got %v", policy.GetActions())
+	}
+}
+
+// subjectID returns the entity ID generated for a subject template
+func subjectID(t *testing.T, template string) string {
+	t.Helper()
+	entity, err := NewEntityBuilderDirector().BuildStandardEntity(template, '<', '>')
+	if err != nil {
+		t.Fatalf("Failed to build entity: %v", err)
+	}
+	return entity.ID
+}
+
+func TestSQLiteRoundTrip(t *testing.T) {
+	config := DefaultConfig()
+	config.StrictCandidates = true
+	manager := newTestManagerWithConfig(t, config)
+	ctx := context.Background()
+
+	policy := &DelimitedPolicy{
+		DefaultPolicy: ladon.DefaultPolicy{
+			ID:          "1",
+			Description: "users can read numbered articles",
+			Subjects:    []string{"user", "admin", "editor"},
+			Effect:      ladon.AllowAccess,
+			Resources:   []string{"article:{[0-9]+}", "article:<draft>"},
+			Actions:     []string{"write", "read"},
+			Conditions:  ladon.Conditions{},
+			Meta:        []byte("{}"),
+		},
+		StartDelimiter: '{',
+		EndDelimiter:   '}',
+	}
+	createTestPolicies(t, manager, policy)
+
+	stored, err := manager.Get(ctx, "1")
+	if err != nil {
+		t.Fatalf("Failed to get policy: %v", err)
+	}
+	if !reflect.DeepEqual(stored, ladon.Policy(policy)) {
+		t.Errorf("Expected policy %+v, got %+v", policy, stored)
+	}
+
+	// The literal <draft> must not be treated as a regex
+	for resource, expected := range map[string]bool{"article:42": true, "article:<draft>": true, "article:draft": false} {
+		candidates, err := manager.FindRequestCandidates(ctx, &ladon.Request{Subject: "admin", Action: "read", Resource: resource})
+		if err != nil {
+			t.Fatalf("Failed to find candidates: %v", err)
+		}
+		if containsID(candidates, "1") != expected {
+			t.Errorf("Expected match of %s to be %v", resource, expected)
+		}
+	}
+
+	policy.Subjects = []string{"editor", "user"}
+	policy.Actions = []string{"read", "write"}
+	if err := manager.Update(ctx, policy); err != nil {
+		t.Fatalf("Failed to update policy: %v", err)
+	}
+	stored, err = manager.Get(ctx, "1")
+	if err != nil {
+		t.Fatalf("Failed to get policy: %v", err)
+	}
+	if !reflect.DeepEqual(stored, ladon.Policy(policy)) {
+		t.Errorf("Expected reordered policy %+v, got %+v", policy, stored)
+	}
+
+	all, err := manager.GetAll(ctx, 10, 0)
+	if err != nil {
+		t.Fatalf("Failed to get policies: %v", err)
+	}
+	if len(all) != 1 || !reflect.DeepEqual(all[0], ladon.Policy(policy)) {
+		t.Errorf("Expected GetAll to return the reordered policy, got %v", all)
+	}
+
+	// A repeated template could not be read back in its positions
+	policy.Subjects = []string{"user", "editor", " user"}
+	err = manager.Update(ctx, policy)
+	var templateErr *TemplateError
+	if !errors.Is(err, ErrDuplicateTemplate) || !errors.As(err, &templateErr) || templateErr.Type != "subject" || templateErr.Template != " user" {
+		t.Errorf("Expected a TemplateError with ErrDuplicateTemplate for subject \" user\", got %v", err)
+	}
+}
+
+func TestSQLiteTemplateDelimiterConflict(t *testing.T) {
+	manager := newTestManager(t)
+	ctx := context.Background()
+
+	createTestPolicies(t, manager, &ladon.DefaultPolicy{
+		ID:          "literal",
+		Description: "a literal subject",
+		Subjects:    []string{"user:{[0-9]+}"},
+		Effect:      ladon.AllowAccess,
+		Resources:   []string{"article"},
+		Actions:     []string{"read"},
+	})
+
+	pattern := &DelimitedPolicy{
+		DefaultPolicy: ladon.DefaultPolicy{
+			ID:          "pattern",
+			Description: "a subject pattern",
+			Subjects:    []string{"user:{[0-9]+}"},
+			Effect:      ladon.AllowAccess,
+			Resources:   []string{"article"},
+			Actions:     []string{"read"},
+		},
+		StartDelimiter: '{',
+		EndDelimiter:   '}',
+	}
+	err := manager.Create(ctx, pattern)
+	var templateErr *TemplateError
+	if !errors.Is(err, ErrTemplateConflict) || !errors.As(err, &templateErr) || templateErr.PolicyID != "pattern" {
+		t.Fatalf("Expected a TemplateError with ErrTemplateConflict for policy pattern, got %v", err)
+	}
+	if _, err := manager.Get(ctx, "pattern"); !errors.Is(err, ErrPolicyNotFound) {
+		t.Errorf("Expected the conflicting policy not to be stored, got %v", err)
+	}
+
+	// The literal policy still only matches its literal template
+	candidates, err := manager.FindRequestCandidates(ctx, &ladon.Request{Subject: "user:{[0-9]+}"})
+	if err != nil {
+		t.Fatalf("Failed to find candidates: %v", err)
+	}
+	if ids := policyIDs(candidates); len(ids) != 1 || ids[0] != "literal" {
+		t.Errorf("Expected only the literal policy, got %v", ids)
+	}
+
+	// Switching the delimiters of the literal policy would change its stored template
+	pattern.ID = "literal"
+	if err := manager.Update(ctx, pattern); !errors.Is(err, ErrTemplateConflict) {
+		t.Errorf("Expected ErrTemplateConflict when changing the delimiters, got %v", err)
+	}
+}
+
+func TestDelimitedPolicyJSON(t *testing.T) {
+	policy := &DelimitedPolicy{
+		DefaultPolicy: ladon.DefaultPolicy{
+			ID:         "1",
+			Subjects:   []string{"user:{[0-9]+}"},
+			Effect:     ladon.AllowAccess,
+			Resources:  []string{"article"},
+			Actions:    []string{"read"},
+			Conditions: ladon.Conditions{},
+		},
+		StartDelimiter: '{',
+		EndDelimiter:   '}',
+	}
+
+	data, err := json.Marshal(policy)
+	if err != nil {
+		t.Fatalf("Failed to marshal policy: %v", err)
+	}
+	if !strings.Contains(string(data), `"start_delimiter":"{"`) || !strings.Contains(string(data), `"end_delimiter":"}"`) {
+		t.Errorf("Expected the delimiters in the JSON, got %s", data)
+	}
+
+	var decoded DelimitedPolicy
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		t.Fatalf("Failed to unmarshal policy: %v", err)
+	}
+	if decoded.StartDelimiter != '{' || decoded.EndDelimiter != '}' || decoded.ID != "1" || len(decoded.Subjects) != 1 {
+		t.Errorf("Expected policy %+v, got %+v", policy, decoded)
+	}
+
+	if err := json.Unmarshal([]byte(`{"id":"2"}`), &decoded); err != nil {
+		t.Fatalf("Failed to unmarshal policy: %v", err)
+	}
+	if decoded.StartDelimiter != '<' || decoded.EndDelimiter != '>' {
+		t.Errorf("Expected the default delimiters, got %c and %c", decoded.StartDelimiter, decoded.EndDelimiter)
+	}
+}
+
+func TestSQLiteTrash(t *testing.T) {
+	manager := newTestManager(t)
+	ctx := context.Background()
+
+	createTestPolicies(t, manager,
+		&ladon.DefaultPolicy{
+			ID:          "1",
+			Description: "first",
+			Subjects:    []string{"user"},
+			Effect:      ladon.AllowAccess,
+			Resources:   []string{"article:1"},
+			Actions:     []string{"read"},
+		},
+		&ladon.DefaultPolicy{
+			ID:          "2",
+			Description: "second",
+			Subjects:    []string{"user"},
+			Effect:      ladon.AllowAccess,
+			Resources:   []string{"article:2"},
+			Actions:     []string{"read"},
+		},
+	)
+
+	for _, id := range []string{"1", "2"} {
+		if err := manager.Delete(ctx, id); err != nil {
+			t.Fatalf("Delete failed: %v", err)
+		}
+	}
+
+	deleted, err := manager.ListDeleted(ctx, 10, 0)
+	if err != nil {
+		t.Fatalf("ListDeleted failed: %v", err)
+	}
+	if len(deleted) != 2 {
+		t.Fatalf("Expected 2 deleted policies, got %d", len(deleted))
+	}
+	for _, d := range deleted {
+		if d.DeletedAt.IsZero() {
+			t.Errorf("Expected deletion time for policy '%s'", d.Policy.GetID())
+		}
+	}
+
+	if err := manager.Restore(ctx, "1"); err != nil {
+		t.Fatalf("Restore failed: %v", err)
+	}
+	policy, err := manager.Get(ctx, "1")
+	if err != nil {
+		t.Fatalf("Expected restored policy, got %v", err)
+	}
+	if len(policy.GetSubjects()) != 1 || len(policy.GetResources()) != 1 || len(policy.GetActions()) != 1 {
+		t.Errorf("Expected restored policy to keep its relations, got %+v", policy)
+	}
+	if err := manager.Restore(ctx, "1"); err == nil {
+		t.Error("Expected restoring a live policy to fail")
+	}
+
+	// Policy 2 was deleted just now, so a one hour threshold keeps it
+	purged, err := manager.Purge(ctx, time.Hour)
+	if err != nil {
+		t.Fatalf("Purge failed: %v", err)
+	}
+	if purged != 0 {
+		t.Errorf("Expected nothing to be purged, got %d", purged)
+	}
+
+	purged, err = manager.Purge(ctx, 0)
+	if err != nil {
+		t.Fatalf("Purge failed: %v", err)
+	}
+	if purged != 1 {
+		t.Errorf("Expected 1 purged policy, got %d", purged)
+	}
+	if relations := countRelations(t, manager, "2"); relations != 0 {
+		t.Errorf("Expected purged policy to lose its relations, got %d", relations)
+	}
+	if deleted, _ := manager.ListDeleted(ctx, 10, 0); len(deleted) != 0 {
+		t.Errorf("Expected empty trash, got %d policies", len(deleted))
+	}
+}
+
+func TestSQLiteBatchInserts(t *testing.T) {
+	config := DefaultConfig()
+	config.MaxBatchSize = 50
+	manager := newTestManagerWithConfig(t, config)
+
+	var inserts int
+	manager.db.Callback().Create().After("gorm:create").Register("test:count_inserts", func(db *gorm.DB) {
+		inserts++
+	})
+
+	resources := make([]string, 0, 201)
+	for i := 0; i < 200; i++ {
+		resources = append(resources, fmt.Sprintf("article:%d", i))
+	}
+	createTestPolicies(t, manager, &ladon.DefaultPolicy{
+		ID:          "1",
+		Description: "many resources",
+		Subjects:    []string{"user"},
+		Effect:      ladon.AllowAccess,
+		Resources:   resources,
+		Actions:     []string{"read"},
+	})
+
+	// 1 policy, 1+1 subject, 1+1 action and 4+4 resource batches
+	if inserts != 13 {
+		t.Errorf("Expected 13 insert statements, got %d", inserts)
+	}
+
+	var count int64
+	manager.db.Model(&models.PolicyResourceRel{}).Where("policy = ?", "1").Count(&count)
+	if count != 200 {
+		t.Errorf("Expected 200 resource relations, got %d", count)
+	}
+
+	// Entities shared with an existing policy are reused
+	createTestPolicies(t, manager, &ladon.DefaultPolicy{
+		ID:          "2",
+		Description: "shared resources",
+		Subjects:    []string{"user"},
+		Effect:      ladon.AllowAccess,
+		Resources:   resources[:10],
+		Actions:     []string{"read"},
+	})
+	manager.db.Model(&models.Resource{}).Count(&count)
+	if count != 200 {
+		t.Errorf("Expected 200 resource entities, got %d", count)
+	}
+}
+
+func TestSQLiteInsertIgnoringDuplicates(t *testing.T) {
+	manager := newTestManager(t)
+	subject := []models.Subject{{BaseEntity: models.BaseEntity{ID: "subject-1", Template: "user", Compiled: "^user$"}}}
+
+	// Inserting a stored row again is skipped
+	for i := 0; i < 2; i++ {
+		if err := insertIgnoringDuplicates(manager.db, subject, 10); err != nil {
+			t.Fatalf("Expected no error inserting the subject, got %v", err)
+		}
+	}
+
+	// The same template under a second ID violates the unique index
+	second := []models.Subject{{BaseEntity: models.BaseEntity{ID: "subject-2", Template: "user", Compiled: "^user$"}}}
+	if err := insertIgnoringDuplicates(manager.db, second, 10); err == nil {
+		t.Error("Expected an error storing the template under a second ID")
+	}
+	var count int64
+	manager.db.Model(&models.Subject{}).Count(&count)
+	if count != 1 {
+		t.Errorf("Expected 1 subject entity, got %d", count)
+	}
+
+	// A relation to a missing policy violates the foreign key
+	relation := []*models.PolicySubjectRel{{Policy: "missing", Subject: "subject-1"}}
+	if err := insertIgnoringDuplicates(manager.db, relation, 10); err == nil {
+		t.Error("Expected an error storing a relation to a missing policy")
+	}
+}
+
+func TestInvalidDriver(t *testing.T) {
+	manager := New(nil, "oracle")
+
+	if _, err := manager.FindRequestCandidates(context.Background(), &ladon.Request{Subject: "user"}); err != ErrInvalidDriver {
+		t.Errorf("Expected ErrInvalidDriver, got %v", err)
+	}
+}
+
+func TestMySQLRegexQuery(t *testing.T) {
+	// DryRun only renders the statement, so no MySQL server is required
+	db, err := gorm.Open(mysql.New(mysql.Config{
+		DSN:                       "user:pass@tcp(localhost:3306)/ladon",
+		SkipInitializeWithVersion: true,
+	}), &gorm.Config{DryRun: true, DisableAutomaticPing: true})
+	if err != nil {
+		t.Fatalf("Failed to open dry-run database: %v", err)
+	}
+
+	manager := New(db, "mysql")
+	var policies []models.Policy
+	stmt := manager.buildRegexQuery(db.Model(&models.Policy{}), "s", "user").Find(&policies).Statement
+
+	sql := stmt.SQL.String()
+	if !strings.Contains(sql, "REGEXP_LIKE(?, s.compiled, 'c')") {
+		t.Errorf("Expected case-sensitive REGEXP_LIKE in query, got %s", sql)
+	}
+	if strings.Contains(sql, "REGEXP BINARY") {
+		t.Errorf("Expected no REGEXP BINARY in query, got %s", sql)
+	}
+}
@@ -0,0 +1,397 @@
+package ladonsqlmanager
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"regexp"
+	"slices"
+	"sort"
+
+	"github.com/ladonsqlmanager/migrations"
+	"github.com/ladonsqlmanager/models"
+	"github.com/ory/ladon"
+	"github.com/pkg/errors"
+	"gorm.io/gorm"
+)
+
+// LegacyImportReport summarizes an ImportLegacy run
+type LegacyImportReport struct {
+	// InPlace is set when the legacy tables were converted within the manager's database
+	InPlace bool
+	// Policies, Subjects, Actions, Resources and Relations count the imported rows
+	Policies  int
+	Subjects  int
+	Actions   int
+	Resources int
+	Relations int
+	// Probes is the number of subjects whose FindRequestCandidates results were
+	// compared with the legacy data
+	Probes int
+}
+
+// legacyPolicy is a row of the legacy policy table
+type legacyPolicy struct {
+	ID          string
+	Description string
+	Effect      string
+	Conditions  string
+	Meta        sql.NullString
+}
+
+// legacyRelation is a row of a legacy relation table
+type legacyRelation struct {
+	Policy string
+	Entity string
+}
+
+// legacySnapshot holds the rows of a legacy schema by entity type
+type legacySnapshot struct {
+	policies  []legacyPolicy
+	entities  map[string][]models.BaseEntity
+	relations map[string][]legacyRelation
+}
+
+// ImportLegacy imports the policies stored by ory/ladon's SQL manager. With a nil
+// source the legacy tables of the manager's database are converted in place: they
+// are renamed with migrations.LegacyTablePrefix, the current schema is created and
+// their rows are copied over. The renamed tables are dropped once the import is
+// verified, and kept if anything fails. Otherwise the rows are copied from source
+// into the manager's database, which must not hold policies yet.
+//
+// Either way the row counts, and the FindRequestCandidates results for every legacy
+// subject template, are compared with the legacy data. ErrLegacyMismatch is returned
+// when they differ. The imported policies are announced through
+// Config.ChangeTransport. Config.QueryTimeout bounds each statement of the import
+// rather than the whole import.
+func (s *SQLManager) ImportLegacy(ctx context.Context, source *gorm.DB) (_ *LegacyImportReport, err error) {
+	ctx, op := s.startOperation(ctx, "ImportLegacy")
+	defer func() { op.finish(err) }()
+
+	ctx = s.withStatementTimeout(ctx)
+	defer func() { err = timeoutError(err) }()
+
+	inPlace := source == nil
+	if inPlace {
+		source = s.db
+	}
+
+	legacy, err := migrations.IsLegacySchema(source.WithContext(ctx))
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	if !legacy {
+		return nil, errors.WithStack(migrations.ErrNoLegacySchema)
+	}
+
+	snapshot, err := loadLegacySnapshot(source.WithContext(ctx))
+	if err != nil {
+		return nil, err
+	}
+	op.metrics.Rows = len(snapshot.policies)
+
+	opts := []migrations.Option{migrations.WithLogger(s.logger)}
+	if inPlace {
+		if err := migrations.RenameLegacyTables(s.db.WithContext(ctx), opts...); err != nil {
+			return nil, errors.WithStack(err)
+		}
+	}
+
+	report, err := s.importLegacySnapshot(ctx, snapshot, inPlace, opts)
+	if err != nil {
+		if inPlace {
+			return nil, errors.Wrapf(err, "legacy tables kept with the prefix %s", migrations.LegacyTablePrefix)
+		}
+		return nil, err
+	}
+
+	if inPlace {
+		if err := migrations.DropLegacyTables(s.db.WithContext(ctx), opts...); err != nil {
+			return nil, errors.WithStack(err)
+		}
+	}
+	return report, nil
+}
+
+// PlanImportLegacy reports what ImportLegacy would import from source, nil for the
+// manager's database, and records the statements it would run in plan. Neither
+// database is changed.
+func (s *SQLManager) PlanImportLegacy(ctx context.Context, source *gorm.DB, plan *migrations.Plan) (_ *LegacyImportReport, err error) {
+	ctx, op := s.startOperation(ctx, "PlanImportLegacy")
+	defer func() { op.finish(err) }()
+
+	ctx = s.withStatementTimeout(ctx)
+	defer func() { err = timeoutError(err) }()
+
+	inPlace := source == nil
+	if inPlace {
+		source = s.db
+	}
+
+	legacy, err := migrations.IsLegacySchema(source.WithContext(ctx))
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	if !legacy {
+		return nil, errors.WithStack(migrations.ErrNoLegacySchema)
+	}
+
+	snapshot, err := loadLegacySnapshot(source.WithContext(ctx))
+	if err != nil {
+		return nil, err
+	}
+	op.metrics.Rows = len(snapshot.policies)
+
+	db := s.db.WithContext(ctx)
+	opts := []migrations.Option{migrations.WithLogger(s.logger), migrations.WithDryRun(plan)}
+	if inPlace {
+		if err := migrations.RenameLegacyTables(db, opts...); err != nil {
+			return nil, errors.WithStack(err)
+		}
+		opts = append(opts, migrations.WithEmptySchema())
+	}
+	if err := migrations.Migrate(db, opts...); err != nil {
+		return nil, errors.WithStack(err)
+	}
+	if inPlace {
+		if err := migrations.DropLegacyTables(db, opts...); err != nil {
+			return nil, errors.WithStack(err)
+		}
+	}
+
+	report := newLegacyImportReport(snapshot)
+	report.InPlace = inPlace
+	return report, nil
+}
+
+// newLegacyImportReport counts the rows of the snapshot
+func newLegacyImportReport(snapshot *legacySnapshot) *LegacyImportReport {
+	report := &LegacyImportReport{
+		Policies:  len(snapshot.policies),
+		Subjects:  len(snapshot.entities[itemTypeSubject]),
+		Actions:   len(snapshot.entities[itemTypeAction]),
+		Resources: len(snapshot.entities[itemTypeResource]),
+	}
+	for _, rel := range relationTables {
+		report.Relations += len(snapshot.relations[rel.itemType])
+	}
+	return report
+}
+
+// importLegacySnapshot creates the current schema, stores the snapshot and verifies it
+func (s *SQLManager) importLegacySnapshot(ctx context.Context, snapshot *legacySnapshot, inPlace bool, opts []migrations.Option) (*LegacyImportReport, error) {
+	db := s.db.WithContext(ctx)
+	if err := migrations.Migrate(db, opts...); err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	if !inPlace {
+		var existing int64
+		if err := db.Unscoped().Model(&models.Policy{}).Count(&existing).Error; err != nil {
+			return nil, errors.WithStack(err)
+		}
+		if existing > 0 {
+			return nil, errors.WithStack(ErrImportTargetNotEmpty)
+		}
+	}
+
+	err := db.Transaction(func(tx *gorm.DB) error {
+		if err := s.storeLegacySnapshot(snapshot, tx); err != nil {
+			return err
+		}
+		// Caches and mirrors of other instances may hold the policies of earlier imports
+		for _, policy := range snapshot.policies {
+			if err := s.publishChange(tx, policy.ID); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	report, err := s.verifyLegacyImport(ctx, snapshot)
+	if err != nil {
+		return nil, err
+	}
+	report.InPlace = inPlace
+	return report, nil
+}
+
+// loadLegacySnapshot reads all rows of a legacy schema
+func loadLegacySnapshot(db *gorm.DB) (*legacySnapshot, error) {
+	snapshot := &legacySnapshot{
+		entities:  make(map[string][]models.BaseEntity),
+		relations: make(map[string][]legacyRelation),
+	}
+
+	// The meta column was added by a later ory/ladon migration
+	meta := "NULL"
+	if db.Migrator().HasColumn(models.TableNamePolicy, "meta") {
+		meta = "meta"
+	}
+	query := fmt.Sprintf("SELECT id, description, effect, conditions, %s AS meta FROM %s ORDER BY id", meta, models.TableNamePolicy)
+	if err := db.Raw(query).Find(&snapshot.policies).Error; err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	for _, rel := range relationTables {
+		var entities []models.BaseEntity
+		query := fmt.Sprintf("SELECT id, has_regex, compiled, template FROM %s ORDER BY id", rel.entityTable)
+		if err := db.Raw(query).Find(&entities).Error; err != nil {
+			return nil, errors.WithStack(err)
+		}
+		snapshot.entities[rel.itemType] = entities
+
+		var relations []legacyRelation
+		query = fmt.Sprintf("SELECT policy, %s AS entity FROM %s ORDER BY policy, %s", rel.column, rel.table, rel.column)
+		if err := db.Raw(query).Find(&relations).Error; err != nil {
+			return nil, errors.WithStack(err)
+		}
+		snapshot.relations[rel.itemType] = relations
+	}
+	return snapshot, nil
+}
+
+// storeLegacySnapshot inserts the rows of a legacy schema into the current models.
+// Legacy templates have no stored order and always use the default delimiters.
+func (s *SQLManager) storeLegacySnapshot(snapshot *legacySnapshot, tx *gorm.DB) error {
+	policies := make([]models.Policy, len(snapshot.policies))
+	for i, legacy := range snapshot.policies {
+		policies[i] = models.Policy{
+			ID:             legacy.ID,
+			Description:    legacy.Description,
+			Effect:         legacy.Effect,
+			Conditions:     models.JSONText(legacy.Conditions),
+			StartDelimiter: models.DefaultStartDelimiter,
+			EndDelimiter:   models.DefaultEndDelimiter,
+		}
+		if legacy.Meta.Valid {
+			policies[i].Meta = models.JSONText(legacy.Meta.String)
+		}
+	}
+	if err := insertIgnoringDuplicates(tx, policies, s.config.MaxBatchSize); err != nil {
+		return errors.WithStack(err)
+	}
+
+	items := make([]policyItems, 0, len(relationTables))
+	for _, rel := range relationTables {
+		factory, exists := s.factoryRegistry.GetFactory(rel.itemType)
+		if !exists {
+			return errors.Errorf("unsupported entity type: %s", rel.itemType)
+		}
+		built := policyItems{itemType: rel.itemType, entities: snapshot.entities[rel.itemType]}
+		for _, relation := range snapshot.relations[rel.itemType] {
+			built.relations = append(built.relations, factory.CreateRelation(relation.Policy, relation.Entity))
+		}
+		items = append(items, built)
+	}
+
+	if err := s.insertEntities(items, tx); err != nil {
+		return err
+	}
+	return s.persistRelations(items, tx)
+}
+
+// verifyLegacyImport compares the row counts and request candidates of the
+// imported policies with the snapshot
+func (s *SQLManager) verifyLegacyImport(ctx context.Context, snapshot *legacySnapshot) (*LegacyImportReport, error) {
+	report := newLegacyImportReport(snapshot)
+
+	type tableCount struct {
+		table    string
+		expected int
+	}
+	counts := []tableCount{{models.TableNamePolicy, report.Policies}}
+	for _, rel := range relationTables {
+		counts = append(counts,
+			tableCount{rel.entityTable, len(snapshot.entities[rel.itemType])},
+			tableCount{rel.table, len(snapshot.relations[rel.itemType])},
+		)
+	}
+	for _, count := range counts {
+		var rows int64
+		if err := s.db.WithContext(ctx).Table(count.table).Count(&rows).Error; err != nil {
+			return nil, errors.WithStack(err)
+		}
+		if rows != int64(count.expected) {
+			return nil, errors.Wrapf(ErrLegacyMismatch, "%s has %d rows, expected %d", count.table, rows, count.expected)
+		}
+	}
+
+	expected, err := legacyCandidates(snapshot)
+	if err != nil {
+		return nil, err
+	}
+	probes := make([]string, 0, len(expected))
+	for probe := range expected {
+		probes = append(probes, probe)
+	}
+	sort.Strings(probes)
+
+	// Legacy candidates only match the subject
+	verifier := *s
+	verifier.config.StrictCandidates = false
+	for _, probe := range probes {
+		policies, err := verifier.FindRequestCandidates(ctx, &ladon.Request{Subject: probe})
+		if err != nil {
+			return nil, err
+		}
+		ids := make([]string, len(policies))
+		for i, policy := range policies {
+			ids[i] = policy.GetID()
+		}
+		sort.Strings(ids)
+		if !slices.Equal(ids, expected[probe]) {
+			return nil, errors.Wrapf(ErrLegacyMismatch, "candidates for subject %q are %v, expected %v", probe, ids, expected[probe])
+		}
+	}
+	report.Probes = len(probes)
+	return report, nil
+}
+
+// legacyCandidates returns the sorted IDs of the policies ory/ladon's SQL manager
+// finds for every subject template of the snapshot: those with a subject equal to
+// it, or with a subject pattern matching it
+func legacyCandidates(snapshot *legacySnapshot) (map[string][]string, error) {
+	policiesBySubject := make(map[string][]string)
+	for _, relation := range snapshot.relations[itemTypeSubject] {
+		policiesBySubject[relation.Entity] = append(policiesBySubject[relation.Entity], relation.Policy)
+	}
+
+	type pattern struct {
+		regexp   *regexp.Regexp
+		policies []string
+	}
+	exact := make(map[string][]string)
+	var patterns []pattern
+	for _, subject := range snapshot.entities[itemTypeSubject] {
+		if !subject.HasRegex {
+			exact[subject.Template] = append(exact[subject.Template], policiesBySubject[subject.ID]...)
+			continue
+		}
+		compiled, err := regexp.Compile(subject.Compiled)
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid compiled template of legacy subject %s", subject.ID)
+		}
+		patterns = append(patterns, pattern{compiled, policiesBySubject[subject.ID]})
+	}
+
+	candidates := make(map[string][]string)
+	for _, subject := range snapshot.entities[itemTypeSubject] {
+		probe := subject.Template
+		if _, ok := candidates[probe]; ok {
+			continue
+		}
+		ids := append([]string{}, exact[probe]...)
+		for _, p := range patterns {
+			if p.regexp.MatchString(probe) {
+				ids = append(ids, p.policies...)
+			}
+		}
+		sort.Strings(ids)
+		candidates[probe] = slices.Compact(ids)
+	}
+	return candidates, nil
+}
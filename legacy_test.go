@@ -0,0 +1,279 @@
+package ladonsqlmanager
+
+import (
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"slices"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/ladonsqlmanager/migrations"
+	"github.com/ladonsqlmanager/models"
+	"github.com/ory/ladon"
+	"github.com/ory/ladon/compiler"
+	"github.com/pkg/errors"
+	"gorm.io/gorm"
+)
+
+// legacySchema is the schema of ory/ladon's SQL manager after all of its migrations
+var legacySchema = []string{
+	`CREATE TABLE ladon_policy (id varchar(255) NOT NULL PRIMARY KEY, description text NOT NULL, effect text NOT NULL CHECK (effect='allow' OR effect='deny'), conditions text NOT NULL, meta text)`,
+	`CREATE TABLE ladon_subject (id varchar(64) NOT NULL PRIMARY KEY, has_regex bool NOT NULL, compiled varchar(511) NOT NULL UNIQUE, template varchar(511) NOT NULL UNIQUE)`,
+	`CREATE TABLE ladon_action (id varchar(64) NOT NULL PRIMARY KEY, has_regex bool NOT NULL, compiled varchar(511) NOT NULL UNIQUE, template varchar(511) NOT NULL UNIQUE)`,
+	`CREATE TABLE ladon_resource (id varchar(64) NOT NULL PRIMARY KEY, has_regex bool NOT NULL, compiled varchar(511) NOT NULL UNIQUE, template varchar(511) NOT NULL UNIQUE)`,
+	`CREATE TABLE ladon_policy_subject_rel (policy varchar(255) NOT NULL, subject varchar(64) NOT NULL, PRIMARY KEY (policy, subject), FOREIGN KEY (policy) REFERENCES ladon_policy(id) ON DELETE CASCADE, FOREIGN KEY (subject) REFERENCES ladon_subject(id) ON DELETE CASCADE)`,
+	`CREATE TABLE ladon_policy_action_rel (policy varchar(255) NOT NULL, action varchar(64) NOT NULL, PRIMARY KEY (policy, action), FOREIGN KEY (policy) REFERENCES ladon_policy(id) ON DELETE CASCADE, FOREIGN KEY (action) REFERENCES ladon_action(id) ON DELETE CASCADE)`,
+	`CREATE TABLE ladon_policy_resource_rel (policy varchar(255) NOT NULL, resource varchar(64) NOT NULL, PRIMARY KEY (policy, resource), FOREIGN KEY (policy) REFERENCES ladon_policy(id) ON DELETE CASCADE, FOREIGN KEY (resource) REFERENCES ladon_resource(id) ON DELETE CASCADE)`,
+}
+
+// legacyPolicies are stored in the legacy schema by newLegacyTestDB
+var legacyPolicies = []*ladon.DefaultPolicy{
+	{
+		ID:          "1",
+		Description: "users can read articles",
+		Subjects:    []string{"users:<.*>", "alice"},
+		Effect:      ladon.AllowAccess,
+		Resources:   []string{"articles:<[0-9]+>"},
+		Actions:     []string{"read"},
+		Conditions:  ladon.Conditions{"department": &ladon.StringEqualCondition{Equals: "editorial"}},
+		Meta:        []byte(`{"team":"editorial"}`),
+	},
+	{
+		ID:          "2",
+		Description: "bob can delete articles",
+		Subjects:    []string{"bob"},
+		Effect:      ladon.DenyAccess,
+		Resources:   []string{"articles:<[0-9]+>"},
+		Actions:     []string{"delete", "read"},
+		Conditions:  ladon.Conditions{},
+	},
+	{
+		ID:          "3",
+		Description: "numbered users can list",
+		Subjects:    []string{"users:<[0-9]+>"},
+		Effect:      ladon.AllowAccess,
+		Resources:   []string{"articles"},
+		Actions:     []string{"list"},
+		Conditions:  ladon.Conditions{},
+	},
+}
+
+// newLegacyTestDB returns an in-memory SQLite database holding legacyPolicies in the
+// schema of ory/ladon's SQL manager
+func newLegacyTestDB(t *testing.T) *gorm.DB {
+	t.Helper()
+	db := newTestDB(t)
+	for _, statement := range legacySchema {
+		if err := db.Exec(statement).Error; err != nil {
+			t.Fatalf("Failed to create legacy schema: %v", err)
+		}
+	}
+
+	stored := make(map[string]bool)
+	for _, policy := range legacyPolicies {
+		conditions, err := policy.Conditions.MarshalJSON()
+		if err != nil {
+			t.Fatalf("Failed to marshal conditions: %v", err)
+		}
+		var meta interface{}
+		if policy.Meta != nil {
+			meta = string(policy.Meta)
+		}
+		err = db.Exec("INSERT INTO ladon_policy (id, description, effect, conditions, meta) VALUES (?, ?, ?, ?, ?)",
+			policy.ID, policy.Description, policy.Effect, string(conditions), meta).Error
+		if err != nil {
+			t.Fatalf("Failed to insert legacy policy: %v", err)
+		}
+
+		for _, item := range []struct {
+			table     string
+			column    string
+			templates []string
+		}{
+			{"subject", "subject", policy.Subjects},
+			{"action", "action", policy.Actions},
+			{"resource", "resource", policy.Resources},
+		} {
+			for _, template := range item.templates {
+				id := fmt.Sprintf("%x", sha256.Sum256([]byte(template)))
+				if !stored[item.table+id] {
+					stored[item.table+id] = true
+					compiled, err := compiler.CompileRegex(template, '<', '>')
+					if err != nil {
+						t.Fatalf("Failed to compile template: %v", err)
+					}
+					err = db.Exec(fmt.Sprintf("INSERT INTO ladon_%s (id, has_regex, compiled, template) VALUES (?, ?, ?, ?)", item.table),
+						id, strings.Contains(template, "<"), compiled.String(), template).Error
+					if err != nil {
+						t.Fatalf("Failed to insert legacy %s: %v", item.table, err)
+					}
+				}
+				err := db.Exec(fmt.Sprintf("INSERT INTO ladon_policy_%s_rel (policy, %s) VALUES (?, ?)", item.table, item.column),
+					policy.ID, id).Error
+				if err != nil {
+					t.Fatalf("Failed to insert legacy relation: %v", err)
+				}
+			}
+		}
+	}
+	return db
+}
+
+// candidateIDs returns the sorted IDs of the request candidates for subject
+func candidateIDs(t *testing.T, manager *SQLManager, subject string) []string {
+	t.Helper()
+	policies, err := manager.FindRequestCandidates(context.Background(), &ladon.Request{Subject: subject})
+	if err != nil {
+		t.Fatalf("Failed to find candidates for %s: %v", subject, err)
+	}
+	ids := make([]string, len(policies))
+	for i, policy := range policies {
+		ids[i] = policy.GetID()
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+// checkLegacyImport verifies that manager returns the legacy policies
+func checkLegacyImport(t *testing.T, manager *SQLManager, report *LegacyImportReport) {
+	t.Helper()
+	if report.Policies != len(legacyPolicies) || report.Subjects != 4 || report.Relations != 11 {
+		t.Errorf("Expected 3 policies, 4 subjects and 11 relations in the report, got %+v", report)
+	}
+	if report.Probes != 4 {
+		t.Errorf("Expected every subject to be probed, got %d probes", report.Probes)
+	}
+
+	for _, expected := range legacyPolicies {
+		policy, err := manager.Get(context.Background(), expected.ID)
+		if err != nil {
+			t.Fatalf("Failed to get imported policy %s: %v", expected.ID, err)
+		}
+		if !equalUnordered(policy.GetSubjects(), expected.Subjects) || !equalUnordered(policy.GetActions(), expected.Actions) {
+			t.Errorf("Expected subjects %v and actions %v, got %v and %v",
+				expected.Subjects, expected.Actions, policy.GetSubjects(), policy.GetActions())
+		}
+		if string(policy.GetMeta()) != string(expected.Meta) {
+			t.Errorf("Expected meta %s, got %s", expected.Meta, policy.GetMeta())
+		}
+		if len(policy.GetConditions()) != len(expected.Conditions) {
+			t.Errorf("Expected %d conditions, got %v", len(expected.Conditions), policy.GetConditions())
+		}
+	}
+
+	if ids := candidateIDs(t, manager, "users:42"); fmt.Sprint(ids) != "[1 3]" {
+		t.Errorf("Expected policies 1 and 3 for users:42, got %v", ids)
+	}
+}
+
+// equalUnordered reports whether a and b hold the same strings in any order
+func equalUnordered(a, b []string) bool {
+	a, b = append([]string{}, a...), append([]string{}, b...)
+	sort.Strings(a)
+	sort.Strings(b)
+	return fmt.Sprint(a) == fmt.Sprint(b)
+}
+
+func TestSQLiteImportLegacyInPlace(t *testing.T) {
+	db := newLegacyTestDB(t)
+	manager := New(db, "sqlite")
+
+	legacy, err := migrations.IsLegacySchema(db)
+	if err != nil || !legacy {
+		t.Fatalf("Expected the legacy schema to be detected, got %v, %v", legacy, err)
+	}
+
+	report, err := manager.ImportLegacy(context.Background(), nil)
+	if err != nil {
+		t.Fatalf("Failed to convert the legacy schema: %v", err)
+	}
+	if !report.InPlace {
+		t.Error("Expected an in place conversion")
+	}
+	checkLegacyImport(t, manager, report)
+
+	if legacy, err := migrations.IsLegacySchema(db); err != nil || legacy {
+		t.Errorf("Expected the converted schema to be current, got %v, %v", legacy, err)
+	}
+	if db.Migrator().HasTable(migrations.LegacyTablePrefix + models.TableNamePolicy) {
+		t.Error("Expected the renamed legacy tables to be dropped")
+	}
+
+	if _, err := manager.ImportLegacy(context.Background(), nil); !errors.Is(err, migrations.ErrNoLegacySchema) {
+		t.Errorf("Expected ErrNoLegacySchema for a converted database, got %v", err)
+	}
+}
+
+func TestSQLiteImportLegacyCopy(t *testing.T) {
+	source := newLegacyTestDB(t)
+	manager := New(newTestDB(t), "sqlite")
+
+	report, err := manager.ImportLegacy(context.Background(), source)
+	if err != nil {
+		t.Fatalf("Failed to copy the legacy schema: %v", err)
+	}
+	if report.InPlace {
+		t.Error("Expected a copy")
+	}
+	checkLegacyImport(t, manager, report)
+
+	if legacy, err := migrations.IsLegacySchema(source); err != nil || !legacy {
+		t.Errorf("Expected the source to keep its legacy schema, got %v, %v", legacy, err)
+	}
+
+	if _, err := manager.ImportLegacy(context.Background(), source); !errors.Is(err, ErrImportTargetNotEmpty) {
+		t.Errorf("Expected ErrImportTargetNotEmpty for a second copy, got %v", err)
+	}
+}
+
+func TestSQLitePlanImportLegacy(t *testing.T) {
+	db := newLegacyTestDB(t)
+	transport := NewInProcessTransport()
+	config := DefaultConfig()
+	config.ChangeTransport = transport
+	manager := NewWithConfig(db, "sqlite", config)
+
+	var plan migrations.Plan
+	report, err := manager.PlanImportLegacy(context.Background(), nil, &plan)
+	if err != nil {
+		t.Fatalf("Failed to plan the legacy import: %v", err)
+	}
+	if !report.InPlace || report.Policies != len(legacyPolicies) || report.Relations != 11 || report.Probes != 0 {
+		t.Errorf("Expected an in place plan of 3 policies and 11 relations, got %+v", report)
+	}
+
+	changes := plan.Changes()
+	for _, expected := range []string{
+		"alter table " + models.TableNamePolicy,
+		"create table " + models.TableNamePolicy,
+		"drop table " + migrations.LegacyTablePrefix + models.TableNamePolicy,
+	} {
+		if !slices.Contains(changes, expected) {
+			t.Errorf("Expected the plan to %s, got %v", expected, changes)
+		}
+	}
+	if legacy, err := migrations.IsLegacySchema(db); err != nil || !legacy {
+		t.Errorf("Expected the plan to keep the legacy schema, got %v, %v", legacy, err)
+	}
+
+	// The import after the plan announces the imported policies
+	handler := &recordingHandler{listening: make(chan struct{}, 1)}
+	subscription, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() { done <- transport.Subscribe(subscription, handler) }()
+	waitListening(t, handler.listening)
+
+	report, err = manager.ImportLegacy(context.Background(), nil)
+	if err != nil {
+		t.Fatalf("Failed to convert the legacy schema after planning: %v", err)
+	}
+	checkLegacyImport(t, manager, report)
+
+	cancel()
+	<-done
+	if fmt.Sprint(handler.changed) != "[1 2 3]" {
+		t.Errorf("Expected the imported policies to be announced, got %v", handler.changed)
+	}
+}
@@ -0,0 +1,110 @@
+package ladonsqlmanager
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log/slog"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ladonsqlmanager/migrations"
+	"github.com/ory/ladon"
+)
+
+// logRecords decodes the records written by a slog JSON handler
+func logRecords(t *testing.T, buf *bytes.Buffer) []map[string]interface{} {
+	t.Helper()
+	var records []map[string]interface{}
+	for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+		if line == "" {
+			continue
+		}
+		var record map[string]interface{}
+		if err := json.Unmarshal([]byte(line), &record); err != nil {
+			t.Fatalf("Failed to decode log record '%s': %v", line, err)
+		}
+		records = append(records, record)
+	}
+	return records
+}
+
+func TestSQLiteLogger(t *testing.T) {
+	var buf bytes.Buffer
+	config := DefaultConfig()
+	config.Logger = slog.New(slog.NewJSONHandler(&buf, &slog.HandlerOptions{Level: slog.LevelDebug}))
+	manager := newTestManagerWithConfig(t, config)
+
+	if err := manager.Init(); err != nil {
+		t.Fatalf("Init failed: %v", err)
+	}
+	if _, err := manager.Get(context.Background(), "missing"); err == nil {
+		t.Fatal("Expected Get of a missing policy to fail")
+	}
+
+	records := logRecords(t, &buf)
+	messages := map[string]bool{}
+	for _, record := range records {
+		messages[record["msg"].(string)] = true
+	}
+	if !messages["Running database migrations"] || !messages["Database migrations completed successfully"] {
+		t.Errorf("Expected migration records, got %v", messages)
+	}
+
+	last := records[len(records)-1]
+	if last["level"] != "DEBUG" || last["operation"] != "Get" || last["error"] == nil {
+		t.Errorf("Expected a debug record of the failed Get, got %v", last)
+	}
+}
+
+func TestSQLiteSlowQueryLog(t *testing.T) {
+	var buf bytes.Buffer
+	config := DefaultConfig()
+	config.EnableMetrics = true
+	config.SlowQueryThreshold = -time.Second
+	config.Logger = slog.New(slog.NewJSONHandler(&buf, nil))
+	manager := newTestManagerWithConfig(t, config)
+
+	createTestPolicies(t, manager, &ladon.DefaultPolicy{
+		ID:          "1",
+		Description: "users can read articles",
+		Subjects:    []string{"user"},
+		Effect:      ladon.AllowAccess,
+		Resources:   []string{"article:1"},
+		Actions:     []string{"read"},
+	})
+
+	records := logRecords(t, &buf)
+	last := records[len(records)-1]
+	if last["level"] != "WARN" || last["msg"] != "Slow query" || last["operation"] != "Create" {
+		t.Errorf("Expected a slow query warning for Create, got %v", last)
+	}
+	for _, record := range records {
+		if record["level"] == "DEBUG" {
+			t.Errorf("Expected no debug records at the default level, got %v", record)
+		}
+	}
+}
+
+func TestSilentByDefault(t *testing.T) {
+	var buf bytes.Buffer
+	previous := slog.Default()
+	slog.SetDefault(slog.New(slog.NewTextHandler(&buf, &slog.HandlerOptions{Level: slog.LevelDebug})))
+	defer slog.SetDefault(previous)
+
+	manager := newTestManager(t)
+	if err := manager.Init(); err != nil {
+		t.Fatalf("Init failed: %v", err)
+	}
+	if err := migrations.ResetDatabase(manager.db); err != nil {
+		t.Fatalf("ResetDatabase failed: %v", err)
+	}
+	if _, err := manager.GetAll(context.Background(), 10, 0); err != nil {
+		t.Fatalf("GetAll failed: %v", err)
+	}
+
+	if buf.Len() != 0 {
+		t.Errorf("Expected no log output, got %s", buf.String())
+	}
+}
@@ -0,0 +1,190 @@
+package ladonsqlmanager
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+// OperationMetrics describes a single finished SQLManager call
+type OperationMetrics struct {
+	// Operation is the name of the SQLManager method, e.g. "FindRequestCandidates"
+	Operation string
+	// Duration is the wall time of the call
+	Duration time.Duration
+	// Rows is the number of policies the call read from or wrote to the database
+	Rows int
+	// Candidates is the number of policies returned by the FindRequestCandidates and
+	// FindPoliciesFor* methods after matching, and zero for all other methods
+	Candidates int
+	// Err is the error returned by the call, if any
+	Err error
+}
+
+// MetricsRecorder receives the metrics of every SQLManager call. Implementations
+// must be safe for concurrent use.
+type MetricsRecorder interface {
+	RecordOperation(metrics OperationMetrics)
+}
+
+// DefaultLatencyBuckets are the upper bounds in seconds of the latency histogram
+// kept by InMemoryMetrics
+var DefaultLatencyBuckets = []float64{0.001, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+// OperationStats aggregates the metrics of all calls of one operation
+type OperationStats struct {
+	Calls         int64
+	Errors        int64
+	Rows          int64
+	Candidates    int64
+	TotalDuration time.Duration
+	MaxDuration   time.Duration
+	// BucketCounts holds the number of calls per latency bucket, not cumulative.
+	// The last entry counts the calls slower than the largest bucket.
+	BucketCounts []int64
+}
+
+// InMemoryMetrics is a MetricsRecorder that aggregates metrics per operation in
+// memory. It serves them in the Prometheus text format as an http.Handler.
+type InMemoryMetrics struct {
+	mu         sync.Mutex
+	buckets    []float64
+	operations map[string]*OperationStats
+}
+
+// NewInMemoryMetrics creates an InMemoryMetrics using DefaultLatencyBuckets
+func NewInMemoryMetrics() *InMemoryMetrics {
+	return NewInMemoryMetricsWithBuckets(DefaultLatencyBuckets)
+}
+
+// NewInMemoryMetricsWithBuckets creates an InMemoryMetrics with custom latency
+// buckets, given as upper bounds in seconds
+func NewInMemoryMetricsWithBuckets(buckets []float64) *InMemoryMetrics {
+	sorted := append([]float64(nil), buckets...)
+	sort.Float64s(sorted)
+	return &InMemoryMetrics{
+		buckets:    sorted,
+		operations: make(map[string]*OperationStats),
+	}
+}
+
+// RecordOperation implements MetricsRecorder
+func (m *InMemoryMetrics) RecordOperation(metrics OperationMetrics) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	stats, exists := m.operations[metrics.Operation]
+	if !exists {
+		stats = &OperationStats{BucketCounts: make([]int64, len(m.buckets)+1)}
+		m.operations[metrics.Operation] = stats
+	}
+
+	stats.Calls++
+	if metrics.Err != nil {
+		stats.Errors++
+	}
+	stats.Rows += int64(metrics.Rows)
+	stats.Candidates += int64(metrics.Candidates)
+	stats.TotalDuration += metrics.Duration
+	if metrics.Duration > stats.MaxDuration {
+		stats.MaxDuration = metrics.Duration
+	}
+	stats.BucketCounts[sort.SearchFloat64s(m.buckets, metrics.Duration.Seconds())]++
+}
+
+// Snapshot returns a copy of the aggregated metrics keyed by operation
+func (m *InMemoryMetrics) Snapshot() map[string]OperationStats {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	snapshot := make(map[string]OperationStats, len(m.operations))
+	for operation, stats := range m.operations {
+		copied := *stats
+		copied.BucketCounts = append([]int64(nil), stats.BucketCounts...)
+		snapshot[operation] = copied
+	}
+	return snapshot
+}
+
+// Reset discards all aggregated metrics
+func (m *InMemoryMetrics) Reset() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.operations = make(map[string]*OperationStats)
+}
+
+// WritePrometheus writes the aggregated metrics in the Prometheus text exposition format
+func (m *InMemoryMetrics) WritePrometheus(w io.Writer) error {
+	snapshot := m.Snapshot()
+	operations := make([]string, 0, len(snapshot))
+	for operation := range snapshot {
+		operations = append(operations, operation)
+	}
+	sort.Strings(operations)
+
+	pw := &prometheusWriter{w: w}
+
+	pw.header("ladonsqlmanager_operation_duration_seconds", "Latency of SQLManager operations.", "histogram")
+	for _, operation := range operations {
+		stats := snapshot[operation]
+		var cumulative int64
+		for i, bound := range m.buckets {
+			cumulative += stats.BucketCounts[i]
+			pw.printf("ladonsqlmanager_operation_duration_seconds_bucket{operation=%q,le=%q} %d\n", operation, formatFloat(bound), cumulative)
+		}
+		pw.printf("ladonsqlmanager_operation_duration_seconds_bucket{operation=%q,le=\"+Inf\"} %d\n", operation, stats.Calls)
+		pw.printf("ladonsqlmanager_operation_duration_seconds_sum{operation=%q} %s\n", operation, formatFloat(stats.TotalDuration.Seconds()))
+		pw.printf("ladonsqlmanager_operation_duration_seconds_count{operation=%q} %d\n", operation, stats.Calls)
+	}
+
+	counters := []struct {
+		name  string
+		help  string
+		value func(OperationStats) int64
+	}{
+		{"ladonsqlmanager_operation_errors_total", "Number of SQLManager operations that returned an error.", func(s OperationStats) int64 { return s.Errors }},
+		{"ladonsqlmanager_operation_rows_total", "Number of policies read or written by SQLManager operations.", func(s OperationStats) int64 { return s.Rows }},
+		{"ladonsqlmanager_operation_candidates_total", "Number of candidate policies returned by SQLManager lookups.", func(s OperationStats) int64 { return s.Candidates }},
+	}
+	for _, counter := range counters {
+		pw.header(counter.name, counter.help, "counter")
+		for _, operation := range operations {
+			pw.printf("%s{operation=%q} %d\n", counter.name, operation, counter.value(snapshot[operation]))
+		}
+	}
+
+	return pw.err
+}
+
+// ServeHTTP serves the metrics in the Prometheus text exposition format
+func (m *InMemoryMetrics) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	if err := m.WritePrometheus(w); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+// prometheusWriter writes metric lines and keeps the first write error
+type prometheusWriter struct {
+	w   io.Writer
+	err error
+}
+
+func (pw *prometheusWriter) header(name, help, metricType string) {
+	pw.printf("# HELP %s %s\n# TYPE %s %s\n", name, help, name, metricType)
+}
+
+func (pw *prometheusWriter) printf(format string, args ...interface{}) {
+	if pw.err != nil {
+		return
+	}
+	_, pw.err = fmt.Fprintf(pw.w, format, args...)
+}
+
+// formatFloat formats a float the way Prometheus clients do
+func formatFloat(f float64) string {
+	return fmt.Sprintf("%g", f)
+}
@@ -0,0 +1,136 @@
+package ladonsqlmanager
+
+import (
+	"context"
+	"errors"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ory/ladon"
+)
+
+func TestInMemoryMetrics(t *testing.T) {
+	metrics := NewInMemoryMetricsWithBuckets([]float64{0.1, 0.01})
+
+	metrics.RecordOperation(OperationMetrics{Operation: "Get", Duration: 5 * time.Millisecond, Rows: 1})
+	metrics.RecordOperation(OperationMetrics{Operation: "Get", Duration: 50 * time.Millisecond, Err: errors.New("boom")})
+	metrics.RecordOperation(OperationMetrics{Operation: "Get", Duration: time.Second, Rows: 1})
+	metrics.RecordOperation(OperationMetrics{Operation: "FindRequestCandidates", Duration: time.Millisecond, Rows: 4, Candidates: 3})
+
+	snapshot := metrics.Snapshot()
+	get := snapshot["Get"]
+	if get.Calls != 3 || get.Errors != 1 || get.Rows != 2 {
+		t.Errorf("Expected 3 calls, 1 error and 2 rows, got %+v", get)
+	}
+	if get.MaxDuration != time.Second {
+		t.Errorf("Expected max duration 1s, got %v", get.MaxDuration)
+	}
+	if len(get.BucketCounts) != 3 || get.BucketCounts[0] != 1 || get.BucketCounts[1] != 1 || get.BucketCounts[2] != 1 {
+		t.Errorf("Expected one call per bucket, got %v", get.BucketCounts)
+	}
+	if snapshot["FindRequestCandidates"].Candidates != 3 {
+		t.Errorf("Expected 3 candidates, got %d", snapshot["FindRequestCandidates"].Candidates)
+	}
+
+	// Snapshots are copies
+	get.BucketCounts[0] = 100
+	if metrics.Snapshot()["Get"].BucketCounts[0] != 1 {
+		t.Error("Expected snapshot to be independent of the recorder")
+	}
+
+	metrics.Reset()
+	if len(metrics.Snapshot()) != 0 {
+		t.Error("Expected no metrics after Reset")
+	}
+}
+
+func TestInMemoryMetricsPrometheus(t *testing.T) {
+	metrics := NewInMemoryMetricsWithBuckets([]float64{0.01, 0.1})
+	metrics.RecordOperation(OperationMetrics{Operation: "Get", Duration: 5 * time.Millisecond, Rows: 1})
+	metrics.RecordOperation(OperationMetrics{Operation: "Get", Duration: 50 * time.Millisecond, Err: errors.New("boom")})
+
+	recorder := httptest.NewRecorder()
+	metrics.ServeHTTP(recorder, httptest.NewRequest("GET", "/metrics", nil))
+
+	if !strings.HasPrefix(recorder.Header().Get("Content-Type"), "text/plain; version=0.0.4") {
+		t.Errorf("Expected Prometheus text content type, got '%s'", recorder.Header().Get("Content-Type"))
+	}
+
+	body := recorder.Body.String()
+	expected := []string{
+		"# TYPE ladonsqlmanager_operation_duration_seconds histogram",
+		`ladonsqlmanager_operation_duration_seconds_bucket{operation="Get",le="0.01"} 1`,
+		`ladonsqlmanager_operation_duration_seconds_bucket{operation="Get",le="0.1"} 2`,
+		`ladonsqlmanager_operation_duration_seconds_bucket{operation="Get",le="+Inf"} 2`,
+		`ladonsqlmanager_operation_duration_seconds_sum{operation="Get"} 0.055`,
+		`ladonsqlmanager_operation_duration_seconds_count{operation="Get"} 2`,
+		"# TYPE ladonsqlmanager_operation_errors_total counter",
+		`ladonsqlmanager_operation_errors_total{operation="Get"} 1`,
+		`ladonsqlmanager_operation_rows_total{operation="Get"} 1`,
+		`ladonsqlmanager_operation_candidates_total{operation="Get"} 0`,
+	}
+	for _, line := range expected {
+		if !strings.Contains(body, line+"\n") {
+			t.Errorf("Expected output to contain '%s', got:\n%s", line, body)
+		}
+	}
+}
+
+func TestSQLiteMetrics(t *testing.T) {
+	metrics := NewInMemoryMetrics()
+	config := DefaultConfig()
+	config.Metrics = metrics
+	manager := newTestManagerWithConfig(t, config)
+	ctx := context.Background()
+
+	createTestPolicies(t, manager,
+		&ladon.DefaultPolicy{
+			ID:          "1",
+			Description: "users can read articles",
+			Subjects:    []string{"user"},
+			Effect:      ladon.AllowAccess,
+			Resources:   []string{"article:1"},
+			Actions:     []string{"read"},
+		},
+		&ladon.DefaultPolicy{
+			ID:          "2",
+			Description: "admins can read anything",
+			Subjects:    []string{"admin"},
+			Effect:      ladon.AllowAccess,
+			Resources:   []string{"<.*>"},
+			Actions:     []string{"read"},
+		},
+	)
+
+	if _, err := manager.FindRequestCandidates(ctx, &ladon.Request{Subject: "user", Action: "read", Resource: "article:1"}); err != nil {
+		t.Fatalf("FindRequestCandidates failed: %v", err)
+	}
+	if _, err := manager.GetAll(ctx, 10, 0); err != nil {
+		t.Fatalf("GetAll failed: %v", err)
+	}
+	if _, err := manager.Get(ctx, "missing"); err == nil {
+		t.Fatal("Expected Get of a missing policy to fail")
+	}
+	if err := manager.Delete(ctx, "2"); err != nil {
+		t.Fatalf("Delete failed: %v", err)
+	}
+
+	snapshot := metrics.Snapshot()
+	if stats := snapshot["Create"]; stats.Calls != 2 || stats.Rows != 2 || stats.Errors != 0 {
+		t.Errorf("Expected 2 successful creates, got %+v", stats)
+	}
+	if stats := snapshot["FindRequestCandidates"]; stats.Calls != 1 || stats.Candidates != 1 {
+		t.Errorf("Expected 1 candidate, got %+v", stats)
+	}
+	if stats := snapshot["GetAll"]; stats.Rows != 2 {
+		t.Errorf("Expected GetAll to read 2 rows, got %+v", stats)
+	}
+	if stats := snapshot["Get"]; stats.Errors != 1 || stats.Rows != 0 {
+		t.Errorf("Expected 1 failed Get, got %+v", stats)
+	}
+	if stats := snapshot["Delete"]; stats.Calls != 1 || stats.Rows != 1 {
+		t.Errorf("Expected Delete to remove 1 row, got %+v", stats)
+	}
+}
@@ -0,0 +1,150 @@
+package migrations
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"regexp"
+	"strings"
+
+	"gorm.io/gorm"
+)
+
+// Plan collects what a dry run would do
+type Plan struct {
+	// Steps lists the migrations that would be applied or reverted, in order
+	Steps []PlannedStep
+	// Statements are the SQL statements that would be executed, in order
+	Statements []string
+}
+
+// PlannedStep is a migration a dry run would apply, or revert if Revert is set
+type PlannedStep struct {
+	Version int64
+	Name    string
+	Revert  bool
+}
+
+// WithDryRun records the statements that change the database in plan instead of
+// executing them. Queries reading the schema still run, so the plan reflects the
+// current database. Steps are planned one after the other against that database,
+// so a step depending on the changes of an earlier pending step may be planned
+// with more statements than it would execute.
+func WithDryRun(plan *Plan) Option {
+	return func(o *options) {
+		o.plan = plan
+	}
+}
+
+// WithEmptySchema makes a dry run plan as if the database held no tables, for
+// migrations following statements of the same plan that drop or rename them.
+// It has no effect without WithDryRun.
+func WithEmptySchema() Option {
+	return func(o *options) {
+		o.emptySchema = true
+	}
+}
+
+// prepare returns db, or a session recording its statements into the dry run plan.
+// The session works on a copy of the statement, so db keeps executing statements.
+func (o *options) prepare(db *gorm.DB) *gorm.DB {
+	if o.plan == nil {
+		return db
+	}
+	ctx := db.Statement.Context
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	// A session with a context gets its own statement
+	session := db.Session(&gorm.Session{NewDB: true, Context: ctx})
+	session.Statement.ConnPool = &recordingPool{
+		ConnPool:    session.Statement.ConnPool,
+		dialector:   db.Dialector,
+		plan:        o.plan,
+		emptySchema: o.emptySchema,
+	}
+	return session
+}
+
+// catalogCount matches the queries with which the migrator checks for tables,
+// columns, indexes and constraints
+var catalogCount = regexp.MustCompile(`(?i)^\s*SELECT count\(\*\) FROM (?:information_schema|sqlite_master|pg_)`)
+
+// recordingPool passes queries through to the database and records statements
+// instead of executing them. With emptySchema the catalog queries find nothing.
+type recordingPool struct {
+	gorm.ConnPool
+	dialector   gorm.Dialector
+	plan        *Plan
+	emptySchema bool
+}
+
+// QueryContext runs the query, or finds nothing for catalog queries on an empty schema
+func (p *recordingPool) QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error) {
+	if p.emptySchema && catalogCount.MatchString(query) {
+		return p.ConnPool.QueryContext(ctx, "SELECT 0")
+	}
+	return p.ConnPool.QueryContext(ctx, query, args...)
+}
+
+// QueryRowContext runs the query, or finds nothing for catalog queries on an empty schema
+func (p *recordingPool) QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row {
+	if p.emptySchema && catalogCount.MatchString(query) {
+		return p.ConnPool.QueryRowContext(ctx, "SELECT 0")
+	}
+	return p.ConnPool.QueryRowContext(ctx, query, args...)
+}
+
+// ExecContext records the statement
+func (p *recordingPool) ExecContext(_ context.Context, query string, args ...interface{}) (sql.Result, error) {
+	p.plan.Statements = append(p.plan.Statements, p.dialector.Explain(query, args...))
+	return driver.RowsAffected(0), nil
+}
+
+// BeginTx starts a transaction that is never sent to the database
+func (p *recordingPool) BeginTx(context.Context, *sql.TxOptions) (gorm.ConnPool, error) {
+	return &recordingTx{p}, nil
+}
+
+// recordingTx is the transaction of a recordingPool
+type recordingTx struct {
+	*recordingPool
+}
+
+func (*recordingTx) Commit() error   { return nil }
+func (*recordingTx) Rollback() error { return nil }
+
+// changePatterns turn DDL statements into plan summary lines
+var changePatterns = []struct {
+	pattern *regexp.Regexp
+	format  string
+}{
+	{regexp.MustCompile("(?i)^CREATE TABLE (?:IF NOT EXISTS )?[`\"]?(\\w+)"), "create table $1"},
+	{regexp.MustCompile("(?i)^DROP TABLE (?:IF EXISTS )?[`\"]?(\\w+)"), "drop table $1"},
+	{regexp.MustCompile("(?i)^ALTER TABLE [`\"]?(\\w+)"), "alter table $1"},
+	{regexp.MustCompile("(?i)^CREATE (?:UNIQUE )?INDEX (?:IF NOT EXISTS )?[`\"]?(\\w+)[`\"]? ON [`\"]?(\\w+)"), "create index $1 on $2"},
+	{regexp.MustCompile("(?i)^DROP INDEX (?:IF EXISTS )?[`\"]?(\\w+)"), "drop index $1"},
+}
+
+// Changes summarizes the tables and indexes the statements create, alter or drop,
+// in the order they are first changed
+func (p *Plan) Changes() []string {
+	changes := make([]string, 0, len(p.Statements))
+	seen := make(map[string]bool)
+	for _, statement := range p.Statements {
+		statement = strings.TrimSpace(statement)
+		for _, change := range changePatterns {
+			match := change.pattern.FindStringSubmatchIndex(statement)
+			if match == nil {
+				continue
+			}
+			summary := string(change.pattern.ExpandString(nil, change.format, statement, match))
+			if !seen[summary] {
+				seen[summary] = true
+				changes = append(changes, summary)
+			}
+			break
+		}
+	}
+	return changes
+}
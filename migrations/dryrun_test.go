@@ -0,0 +1,109 @@
+package migrations
+
+import (
+	"slices"
+	"testing"
+
+	"github.com/ladonsqlmanager/models"
+	"gorm.io/gorm"
+)
+
+func TestSQLiteMigrationDryRun(t *testing.T) {
+	db := newTestDB(t)
+
+	var plan Plan
+	if err := Migrate(db, WithDryRun(&plan)); err != nil {
+		t.Fatalf("Dry run of Migrate failed: %v", err)
+	}
+	if db.Migrator().HasTable(&models.Policy{}) {
+		t.Error("Expected a dry run to create no tables")
+	}
+	if len(plan.Steps) != int(LatestVersion()) {
+		t.Errorf("Expected %d planned migrations, got %v", LatestVersion(), plan.Steps)
+	}
+	if !slices.Contains(plan.Changes(), "create table "+models.TableNamePolicy) {
+		t.Errorf("Expected the plan to create %s, got %v", models.TableNamePolicy, plan.Changes())
+	}
+
+	if err := Migrate(db); err != nil {
+		t.Fatalf("Migrate failed: %v", err)
+	}
+	plan = Plan{}
+	if err := To(db, 1, WithDryRun(&plan)); err != nil {
+		t.Fatalf("Dry run of To(1) failed: %v", err)
+	}
+	if len(plan.Steps) != 1 || !plan.Steps[0].Revert || plan.Steps[0].Version != 2 {
+		t.Errorf("Expected the plan to revert migration 2, got %v", plan.Steps)
+	}
+
+	plan = Plan{}
+	if err := DropTables(db, WithDryRun(&plan)); err != nil {
+		t.Fatalf("Dry run of DropTables failed: %v", err)
+	}
+	if !db.Migrator().HasTable(&models.Policy{}) {
+		t.Error("Expected a dry run to keep the tables")
+	}
+	if !slices.Contains(plan.Changes(), "drop table "+models.TableNamePolicy) {
+		t.Errorf("Expected the plan to drop %s, got %v", models.TableNamePolicy, plan.Changes())
+	}
+	if versions := appliedVersions(t, db); len(versions) != int(LatestVersion()) {
+		t.Errorf("Expected dry runs to leave the applied migrations, got %v", versions)
+	}
+}
+
+// checkHandleWrites fails the test unless statements through db are still executed
+func checkHandleWrites(t *testing.T, db *gorm.DB) {
+	t.Helper()
+	if _, err := db.DB(); err != nil {
+		t.Fatalf("Expected the handle to keep its connection pool, got %v", err)
+	}
+	policy := models.Policy{ID: "after-dry-run", Effect: "allow", Conditions: models.JSONText("{}")}
+	if err := db.Create(&policy).Error; err != nil {
+		t.Fatalf("Failed to write through the handle: %v", err)
+	}
+	var count int64
+	if err := db.Model(&models.Policy{}).Where("id = ?", policy.ID).Count(&count).Error; err != nil || count != 1 {
+		t.Errorf("Expected the write to be executed, got %d rows and %v", count, err)
+	}
+}
+
+func TestSQLiteDropTablesDryRunKeepsHandle(t *testing.T) {
+	db := newTestDB(t)
+	if err := Migrate(db); err != nil {
+		t.Fatalf("Migrate failed: %v", err)
+	}
+
+	var plan Plan
+	if err := DropTables(db, WithDryRun(&plan)); err != nil {
+		t.Fatalf("Dry run of DropTables failed: %v", err)
+	}
+	if len(plan.Statements) == 0 {
+		t.Error("Expected the drops to be recorded")
+	}
+	checkHandleWrites(t, db)
+}
+
+func TestSQLiteResetDatabaseDryRun(t *testing.T) {
+	db := newTestDB(t)
+	if err := Migrate(db); err != nil {
+		t.Fatalf("Migrate failed: %v", err)
+	}
+
+	var plan Plan
+	if err := ResetDatabase(db, WithDryRun(&plan)); err != nil {
+		t.Fatalf("Dry run of ResetDatabase failed: %v", err)
+	}
+	if len(plan.Steps) != int(LatestVersion()) {
+		t.Errorf("Expected all %d migrations to be planned after the drops, got %v", LatestVersion(), plan.Steps)
+	}
+	changes := plan.Changes()
+	drop := slices.Index(changes, "drop table "+models.TableNamePolicy)
+	create := slices.Index(changes, "create table "+models.TableNamePolicy)
+	if drop < 0 || create < drop {
+		t.Errorf("Expected the plan to drop and then create %s, got %v", models.TableNamePolicy, changes)
+	}
+	if versions := appliedVersions(t, db); len(versions) != int(LatestVersion()) {
+		t.Errorf("Expected the dry run to leave the applied migrations, got %v", versions)
+	}
+	checkHandleWrites(t, db)
+}
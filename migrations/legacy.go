@@ -0,0 +1,117 @@
+package migrations
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+
+	"github.com/ladonsqlmanager/models"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// LegacyTablePrefix is prepended to the names of the legacy tables while they are
+// converted in place
+const LegacyTablePrefix = "legacy_"
+
+var (
+	// ErrNoLegacySchema returned when a database holds no legacy ladon schema
+	ErrNoLegacySchema = errors.New("no legacy ladon schema found")
+	// ErrUnsupportedLegacySchema returned for legacy schemas that predate the
+	// ladon_subject, ladon_action and ladon_resource tables
+	ErrUnsupportedLegacySchema = errors.New("unsupported legacy ladon schema, upgrade it with ory/ladon first")
+)
+
+// legacyTables are the tables of the legacy schema, relations first
+var legacyTables = []string{
+	models.TableNamePolicyResourceRel,
+	models.TableNamePolicyActionRel,
+	models.TableNamePolicySubjectRel,
+	models.TableNameResource,
+	models.TableNameAction,
+	models.TableNameSubject,
+	models.TableNamePolicy,
+}
+
+// IsLegacySchema reports whether db holds the schema of ory/ladon's SQL manager. It
+// uses the same table names and entity IDs, but has no created_at and deleted_at
+// columns and declares some columns with other types.
+func IsLegacySchema(db *gorm.DB) (bool, error) {
+	// Unlike HasTable and HasColumn these report failing queries
+	tables, err := db.Migrator().GetTables()
+	if err != nil {
+		return false, fmt.Errorf("failed to list the tables: %w", err)
+	}
+	if !slices.Contains(tables, models.TableNamePolicy) {
+		return false, nil
+	}
+	columns, err := db.Migrator().ColumnTypes(models.TableNamePolicy)
+	if err != nil {
+		return false, fmt.Errorf("failed to read the columns of %s: %w", models.TableNamePolicy, err)
+	}
+	for _, column := range columns {
+		if name := column.Name(); name == "created_at" || name == "deleted_at" {
+			return false, nil
+		}
+	}
+
+	for _, table := range legacyTables {
+		if !slices.Contains(tables, table) {
+			return false, fmt.Errorf("%w: table %s is missing", ErrUnsupportedLegacySchema, table)
+		}
+	}
+	return true, nil
+}
+
+// RenameLegacyTables moves the legacy tables out of the way of the current schema
+// by prefixing their names with LegacyTablePrefix. PostgreSQL keeps index names
+// unique per schema, so their indexes are renamed as well.
+func RenameLegacyTables(db *gorm.DB, opts ...Option) error {
+	o := newOptions(opts)
+	return withLock(db, o, func(conn *gorm.DB) error {
+		for _, table := range legacyTables {
+			o.logger.Info("Renaming legacy table", "table", table, "to", LegacyTablePrefix+table)
+			if conn.Dialector.Name() == "postgres" {
+				if err := renameIndexes(conn, table); err != nil {
+					return err
+				}
+			}
+			if err := conn.Migrator().RenameTable(table, LegacyTablePrefix+table); err != nil {
+				return fmt.Errorf("failed to rename %s: %w", table, err)
+			}
+		}
+		return nil
+	})
+}
+
+// renameIndexes prefixes the names of the PostgreSQL indexes of table with
+// LegacyTablePrefix
+func renameIndexes(conn *gorm.DB, table string) error {
+	var indexes []string
+	err := conn.Raw("SELECT indexname FROM pg_indexes WHERE schemaname = CURRENT_SCHEMA() AND tablename = ?", table).
+		Scan(&indexes).Error
+	if err != nil {
+		return fmt.Errorf("failed to list the indexes of %s: %w", table, err)
+	}
+	for _, index := range indexes {
+		err := conn.Exec("ALTER INDEX ? RENAME TO ?", clause.Table{Name: index}, clause.Table{Name: LegacyTablePrefix + index}).Error
+		if err != nil {
+			return fmt.Errorf("failed to rename index %s: %w", index, err)
+		}
+	}
+	return nil
+}
+
+// DropLegacyTables drops the tables renamed by RenameLegacyTables
+func DropLegacyTables(db *gorm.DB, opts ...Option) error {
+	o := newOptions(opts)
+	return withLock(db, o, func(conn *gorm.DB) error {
+		for _, table := range legacyTables {
+			o.logger.Info("Dropping legacy table", "table", LegacyTablePrefix+table)
+			if err := conn.Migrator().DropTable(LegacyTablePrefix + table); err != nil {
+				return fmt.Errorf("failed to drop %s: %w", LegacyTablePrefix+table, err)
+			}
+		}
+		return nil
+	})
+}
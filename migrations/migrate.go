@@ -2,40 +2,64 @@ package migrations
 
 import (
 	"fmt"
-	"log"
+	"log/slog"
 
 	"github.com/ladonsqlmanager/models"
 	"gorm.io/gorm"
 )
 
-// Migrate runs database migrations to set up the schema
-func Migrate(db *gorm.DB) error {
-	log.Println("Running database migrations...")
+// mysqlTableOptions creates the MySQL tables with a binary collation so that the
+// unique template indexes and exact template matches are case sensitive, as they
+// are on PostgreSQL and SQLite
+const mysqlTableOptions = "ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_bin"
 
-	// Auto-migrate all models
-	err := db.AutoMigrate(
-		&models.Policy{},
-		&models.Subject{},
-		&models.Action{},
-		&models.Resource{},
-		&models.PolicySubjectRel{},
-		&models.PolicyActionRel{},
-		&models.PolicyResourceRel{},
-	)
+// Option configures Migrate, DropTables and ResetDatabase
+type Option func(*options)
 
-	if err != nil {
+type options struct {
+	logger      *slog.Logger
+	plan        *Plan
+	emptySchema bool
+}
+
+// WithLogger makes the migration functions report their progress to logger. They
+// are silent by default.
+func WithLogger(logger *slog.Logger) Option {
+	return func(o *options) {
+		if logger != nil {
+			o.logger = logger
+		}
+	}
+}
+
+func newOptions(opts []Option) *options {
+	o := &options{logger: slog.New(slog.DiscardHandler)}
+	for _, opt := range opts {
+		opt(o)
+	}
+	return o
+}
+
+// Migrate applies all pending migrations to set up or upgrade the schema
+func Migrate(db *gorm.DB, opts ...Option) error {
+	o := newOptions(opts)
+	o.logger.Info("Running database migrations", "dialect", db.Dialector.Name())
+
+	if err := migrateTo(db, o, LatestVersion()); err != nil {
 		return fmt.Errorf("failed to run migrations: %w", err)
 	}
 
-	log.Println("Database migrations completed successfully")
+	o.logger.Info("Database migrations completed successfully")
 	return nil
 }
 
 // DropTables drops all tables (useful for testing or resetting)
-func DropTables(db *gorm.DB) error {
-	log.Println("Dropping all tables...")
+func DropTables(db *gorm.DB, opts ...Option) error {
+	o := newOptions(opts)
+	o.logger.Info("Dropping all tables", "dialect", db.Dialector.Name())
 
-	err := db.Migrator().DropTable(
+	err := o.prepare(db).Migrator().DropTable(
+		&schemaMigration{},
 		&models.PolicyResourceRel{},
 		&models.PolicyActionRel{},
 		&models.PolicySubjectRel{},
@@ -49,14 +73,15 @@ func DropTables(db *gorm.DB) error {
 		return fmt.Errorf("failed to run migrations: %w", err)
 	}
 
-	log.Println("All tables dropped successfully")
+	o.logger.Info("All tables dropped successfully")
 	return nil
 }
 
-// ResetDatabase drops all tables and recreates them
-func ResetDatabase(db *gorm.DB) error {
-	if err := DropTables(db); err != nil {
+// ResetDatabase drops all tables and recreates them. A dry run plans the migrations
+// as if the drops had been executed.
+func ResetDatabase(db *gorm.DB, opts ...Option) error {
+	if err := DropTables(db, opts...); err != nil {
 		return err
 	}
-	return Migrate(db)
+	return Migrate(db, append(append([]Option(nil), opts...), WithEmptySchema())...)
 }
@@ -0,0 +1,82 @@
+package migrations
+
+import (
+	"time"
+
+	"github.com/ladonsqlmanager/models"
+	"gorm.io/gorm"
+)
+
+// The v1 structs freeze the schema created by migration 1, so that it does not
+// change with the models. Later migrations change the schema on top of it.
+
+type v1Policy struct {
+	ID          string          `gorm:"column:id;type:varchar(255);primaryKey;not null"`
+	Description string          `gorm:"column:description;type:text;not null"`
+	Effect      string          `gorm:"column:effect;type:varchar(16);not null;check:effect IN ('allow', 'deny')"`
+	Conditions  models.JSONText `gorm:"column:conditions;type:text;not null"`
+	Meta        models.JSONText `gorm:"column:meta;type:text"`
+	CreatedAt   time.Time       `gorm:"column:created_at;autoCreateTime"`
+	UpdatedAt   time.Time       `gorm:"column:updated_at;autoUpdateTime"`
+	DeletedAt   gorm.DeletedAt  `gorm:"column:deleted_at;index"`
+}
+
+func (v1Policy) TableName() string { return models.TableNamePolicy }
+
+type v1Entity struct {
+	ID        string         `gorm:"column:id;type:varchar(64);primaryKey;not null"`
+	HasRegex  bool           `gorm:"column:has_regex;type:bool;not null"`
+	Compiled  string         `gorm:"column:compiled;type:varchar(511);uniqueIndex;not null"`
+	Template  string         `gorm:"column:template;type:varchar(511);uniqueIndex;not null"`
+	CreatedAt time.Time      `gorm:"column:created_at;autoCreateTime"`
+	UpdatedAt time.Time      `gorm:"column:updated_at;autoUpdateTime"`
+	DeletedAt gorm.DeletedAt `gorm:"column:deleted_at;index"`
+}
+
+type v1Subject struct {
+	Entity v1Entity `gorm:"embedded"`
+}
+
+func (v1Subject) TableName() string { return models.TableNameSubject }
+
+type v1Action struct {
+	Entity v1Entity `gorm:"embedded"`
+}
+
+func (v1Action) TableName() string { return models.TableNameAction }
+
+type v1Resource struct {
+	Entity v1Entity `gorm:"embedded"`
+}
+
+func (v1Resource) TableName() string { return models.TableNameResource }
+
+type v1PolicySubjectRel struct {
+	Policy     string    `gorm:"column:policy;type:varchar(255);primaryKey;not null"`
+	Subject    string    `gorm:"column:subject;type:varchar(64);primaryKey;not null"`
+	CreatedAt  time.Time `gorm:"column:created_at;autoCreateTime"`
+	PolicyRef  v1Policy  `gorm:"foreignKey:Policy;references:ID;constraint:OnDelete:CASCADE"`
+	SubjectRef v1Subject `gorm:"foreignKey:Subject;references:ID;constraint:OnDelete:CASCADE"`
+}
+
+func (v1PolicySubjectRel) TableName() string { return models.TableNamePolicySubjectRel }
+
+type v1PolicyActionRel struct {
+	Policy    string    `gorm:"column:policy;type:varchar(255);primaryKey;not null"`
+	Action    string    `gorm:"column:action;type:varchar(64);primaryKey;not null"`
+	CreatedAt time.Time `gorm:"column:created_at;autoCreateTime"`
+	PolicyRef v1Policy  `gorm:"foreignKey:Policy;references:ID;constraint:OnDelete:CASCADE"`
+	ActionRef v1Action  `gorm:"foreignKey:Action;references:ID;constraint:OnDelete:CASCADE"`
+}
+
+func (v1PolicyActionRel) TableName() string { return models.TableNamePolicyActionRel }
+
+type v1PolicyResourceRel struct {
+	Policy      string     `gorm:"column:policy;type:varchar(255);primaryKey;not null"`
+	Resource    string     `gorm:"column:resource;type:varchar(64);primaryKey;not null"`
+	CreatedAt   time.Time  `gorm:"column:created_at;autoCreateTime"`
+	PolicyRef   v1Policy   `gorm:"foreignKey:Policy;references:ID;constraint:OnDelete:CASCADE"`
+	ResourceRef v1Resource `gorm:"foreignKey:Resource;references:ID;constraint:OnDelete:CASCADE"`
+}
+
+func (v1PolicyResourceRel) TableName() string { return models.TableNamePolicyResourceRel }
@@ -0,0 +1,356 @@
+package migrations
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/ladonsqlmanager/models"
+	"gorm.io/gorm"
+)
+
+// SchemaMigrationsTable is the table tracking the applied migrations
+const SchemaMigrationsTable = "ladon_schema_migrations"
+
+// advisoryLockID is the PostgreSQL advisory lock key held while migrating
+const advisoryLockID int64 = 0x6c61646f6e
+
+// lockTimeout is how long a migration waits for the migration lock
+var lockTimeout = 60 * time.Second
+
+// lockRetryInterval is how often PostgreSQL retries taking the migration lock
+var lockRetryInterval = 500 * time.Millisecond
+
+var (
+	// ErrUnknownVersion returned when a target version matches no migration
+	ErrUnknownVersion = errors.New("unknown migration version")
+	// ErrLockTimeout returned when another process holds the migration lock for too long
+	ErrLockTimeout = errors.New("timed out waiting for the migration lock")
+)
+
+// Migration is a versioned schema change. Up applies it and Down reverts it, each
+// in a transaction together with the update of SchemaMigrationsTable. MySQL commits
+// schema changes implicitly, so a failing step may be left half applied there.
+type Migration struct {
+	Version int64
+	Name    string
+	Up      func(tx *gorm.DB) error
+	Down    func(tx *gorm.DB) error
+}
+
+// MigrationStatus tells whether a migration is applied
+type MigrationStatus struct {
+	Version   int64
+	Name      string
+	Applied   bool
+	AppliedAt time.Time
+}
+
+// schemaMigration is a row of SchemaMigrationsTable
+type schemaMigration struct {
+	Version   int64     `gorm:"column:version;primaryKey;autoIncrement:false"`
+	Name      string    `gorm:"column:name;type:varchar(255);not null"`
+	AppliedAt time.Time `gorm:"column:applied_at;not null"`
+}
+
+// TableName specifies the table name for schemaMigration
+func (schemaMigration) TableName() string {
+	return SchemaMigrationsTable
+}
+
+// steps are all migrations ordered by version. Version 1 creates the schema from
+// the frozen v1 structs, so it does not change with the models.
+var steps = []Migration{
+	{
+		Version: 1,
+		Name:    "create_schema",
+		Up: func(tx *gorm.DB) error {
+			return withTableOptions(tx).AutoMigrate(
+				&v1Policy{},
+				&v1Subject{},
+				&v1Action{},
+				&v1Resource{},
+				&v1PolicySubjectRel{},
+				&v1PolicyActionRel{},
+				&v1PolicyResourceRel{},
+			)
+		},
+		Down: func(tx *gorm.DB) error {
+			// One by one, relations first: SQLite can't disable foreign keys within
+			// a transaction, and DropTable may reorder the tables it is given
+			for _, model := range []interface{}{
+				&v1PolicyResourceRel{},
+				&v1PolicyActionRel{},
+				&v1PolicySubjectRel{},
+				&v1Resource{},
+				&v1Action{},
+				&v1Subject{},
+				&v1Policy{},
+			} {
+				if err := tx.Migrator().DropTable(model); err != nil {
+					return err
+				}
+			}
+			return nil
+		},
+	},
+	{
+		Version: 2,
+		Name:    "add_template_order_and_delimiters",
+		Up: func(tx *gorm.DB) error {
+			return forEachColumn(tx, func(model interface{}, field string) error {
+				if tx.Migrator().HasColumn(model, field) {
+					return nil
+				}
+				return tx.Migrator().AddColumn(model, field)
+			})
+		},
+		Down: func(tx *gorm.DB) error {
+			return forEachColumn(tx, func(model interface{}, field string) error {
+				if !tx.Migrator().HasColumn(model, field) {
+					return nil
+				}
+				return tx.Migrator().DropColumn(model, field)
+			})
+		},
+	},
+}
+
+// forEachColumn calls fn with the columns added by migration 2
+func forEachColumn(tx *gorm.DB, fn func(model interface{}, field string) error) error {
+	columns := []struct {
+		model interface{}
+		field string
+	}{
+		{&models.Policy{}, "StartDelimiter"},
+		{&models.Policy{}, "EndDelimiter"},
+		{&models.PolicySubjectRel{}, "Ordinal"},
+		{&models.PolicyActionRel{}, "Ordinal"},
+		{&models.PolicyResourceRel{}, "Ordinal"},
+	}
+	for _, column := range columns {
+		if err := fn(column.model, column.field); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// withTableOptions applies the MySQL table options to tables created through tx
+func withTableOptions(tx *gorm.DB) *gorm.DB {
+	if tx.Dialector.Name() == "mysql" {
+		return tx.Set("gorm:table_options", mysqlTableOptions)
+	}
+	return tx
+}
+
+// Migrations returns all migrations ordered by version
+func Migrations() []Migration {
+	return append([]Migration(nil), steps...)
+}
+
+// LatestVersion returns the version of the newest migration
+func LatestVersion() int64 {
+	return steps[len(steps)-1].Version
+}
+
+// Up applies all pending migrations, like Migrate
+func Up(db *gorm.DB, opts ...Option) error {
+	return Migrate(db, opts...)
+}
+
+// Down reverts the most recently applied migration
+func Down(db *gorm.DB, opts ...Option) error {
+	o := newOptions(opts)
+	return withLock(db, o, func(conn *gorm.DB) error {
+		applied, err := appliedMigrations(conn)
+		if err != nil {
+			return err
+		}
+
+		// Migrate to the version below the newest applied one
+		var target int64
+		for i := len(steps) - 1; i >= 0; i-- {
+			if _, ok := applied[steps[i].Version]; !ok {
+				continue
+			}
+			if i > 0 {
+				target = steps[i-1].Version
+			}
+			return migrate(conn, o, applied, target)
+		}
+		o.logger.Info("No migrations to revert")
+		return nil
+	})
+}
+
+// To applies or reverts migrations until the database is at version. Version 0
+// reverts all migrations.
+func To(db *gorm.DB, version int64, opts ...Option) error {
+	o := newOptions(opts)
+	return migrateTo(db, o, version)
+}
+
+// Status lists all migrations and whether they are applied
+func Status(db *gorm.DB) ([]MigrationStatus, error) {
+	applied, err := appliedMigrations(db)
+	if err != nil {
+		return nil, err
+	}
+
+	status := make([]MigrationStatus, len(steps))
+	for i, step := range steps {
+		row, ok := applied[step.Version]
+		status[i] = MigrationStatus{
+			Version:   step.Version,
+			Name:      step.Name,
+			Applied:   ok,
+			AppliedAt: row.AppliedAt,
+		}
+	}
+	return status, nil
+}
+
+// migrateTo migrates to version while holding the migration lock
+func migrateTo(db *gorm.DB, o *options, version int64) error {
+	return withLock(db, o, func(conn *gorm.DB) error {
+		applied, err := appliedMigrations(conn)
+		if err != nil {
+			return err
+		}
+		return migrate(conn, o, applied, version)
+	})
+}
+
+// migrate applies the pending migrations up to target and reverts the applied ones
+// above it
+func migrate(conn *gorm.DB, o *options, applied map[int64]schemaMigration, target int64) error {
+	if target != 0 && !knownVersion(target) {
+		return fmt.Errorf("%w: %d", ErrUnknownVersion, target)
+	}
+	if err := withTableOptions(conn).AutoMigrate(&schemaMigration{}); err != nil {
+		return fmt.Errorf("failed to create %s: %w", SchemaMigrationsTable, err)
+	}
+
+	for _, step := range steps {
+		if _, ok := applied[step.Version]; ok || step.Version > target {
+			continue
+		}
+		o.logger.Info("Applying migration", "version", step.Version, "name", step.Name)
+		if o.plan != nil {
+			o.plan.Steps = append(o.plan.Steps, PlannedStep{Version: step.Version, Name: step.Name})
+		}
+		err := conn.Transaction(func(tx *gorm.DB) error {
+			if err := step.Up(tx); err != nil {
+				return err
+			}
+			return tx.Create(&schemaMigration{Version: step.Version, Name: step.Name, AppliedAt: time.Now().UTC()}).Error
+		})
+		if err != nil {
+			return fmt.Errorf("migration %d %s failed: %w", step.Version, step.Name, err)
+		}
+	}
+
+	for i := len(steps) - 1; i >= 0; i-- {
+		step := steps[i]
+		if _, ok := applied[step.Version]; !ok || step.Version <= target {
+			continue
+		}
+		o.logger.Info("Reverting migration", "version", step.Version, "name", step.Name)
+		if o.plan != nil {
+			o.plan.Steps = append(o.plan.Steps, PlannedStep{Version: step.Version, Name: step.Name, Revert: true})
+		}
+		err := conn.Transaction(func(tx *gorm.DB) error {
+			if err := step.Down(tx); err != nil {
+				return err
+			}
+			return tx.Delete(&schemaMigration{Version: step.Version}).Error
+		})
+		if err != nil {
+			return fmt.Errorf("reverting migration %d %s failed: %w", step.Version, step.Name, err)
+		}
+	}
+	return nil
+}
+
+// appliedMigrations returns the tracked migrations by version. A database without
+// SchemaMigrationsTable has none applied.
+func appliedMigrations(db *gorm.DB) (map[int64]schemaMigration, error) {
+	applied := make(map[int64]schemaMigration)
+	if !db.Migrator().HasTable(&schemaMigration{}) {
+		return applied, nil
+	}
+
+	var rows []schemaMigration
+	if err := db.Order("version").Find(&rows).Error; err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", SchemaMigrationsTable, err)
+	}
+	for _, row := range rows {
+		applied[row.Version] = row
+	}
+	return applied, nil
+}
+
+func knownVersion(version int64) bool {
+	i := sort.Search(len(steps), func(i int) bool { return steps[i].Version >= version })
+	return i < len(steps) && steps[i].Version == version
+}
+
+// withLock runs fn on a single connection holding a database wide lock, so that
+// services starting at the same time migrate one after the other. SQLite relies on
+// its own write lock. The lock is taken for dry runs as well.
+func withLock(db *gorm.DB, o *options, fn func(conn *gorm.DB) error) error {
+	return db.Connection(func(conn *gorm.DB) error {
+		// Start every chain on conn from a clean statement
+		conn = conn.Session(&gorm.Session{NewDB: true})
+
+		switch conn.Dialector.Name() {
+		case "postgres":
+			if err := lockPostgres(conn); err != nil {
+				return err
+			}
+			defer conn.Exec("SELECT pg_advisory_unlock(?)", advisoryLockID)
+
+		case "mysql":
+			var acquired sql.NullInt64
+			if err := conn.Raw("SELECT GET_LOCK(?, ?)", SchemaMigrationsTable, int(lockTimeout.Seconds())).Scan(&acquired).Error; err != nil {
+				return fmt.Errorf("failed to acquire the migration lock: %w", err)
+			}
+			if !acquired.Valid || acquired.Int64 != 1 {
+				return ErrLockTimeout
+			}
+			defer conn.Exec("SELECT RELEASE_LOCK(?)", SchemaMigrationsTable)
+		}
+		return fn(o.prepare(conn))
+	})
+}
+
+// lockPostgres takes the PostgreSQL advisory lock, retrying until lockTimeout has
+// passed. A blocking pg_advisory_lock would wait forever on a hung migrator.
+func lockPostgres(conn *gorm.DB) error {
+	ctx := conn.Statement.Context
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	deadline := time.Now().Add(lockTimeout)
+	for {
+		var acquired bool
+		if err := conn.Raw("SELECT pg_try_advisory_lock(?)", advisoryLockID).Scan(&acquired).Error; err != nil {
+			return fmt.Errorf("failed to acquire the migration lock: %w", err)
+		}
+		if acquired {
+			return nil
+		}
+		if time.Now().After(deadline) {
+			return ErrLockTimeout
+		}
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("failed to acquire the migration lock: %w", ctx.Err())
+		case <-time.After(lockRetryInterval):
+		}
+	}
+}
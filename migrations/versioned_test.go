@@ -0,0 +1,309 @@
+package migrations
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"reflect"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ladonsqlmanager/models"
+	"github.com/mattn/go-sqlite3"
+	"gorm.io/driver/postgres"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// newTestDB returns a fresh, empty in-memory SQLite database
+func newTestDB(t *testing.T) *gorm.DB {
+	t.Helper()
+
+	db, err := gorm.Open(sqlite.Open("file::memory:?_foreign_keys=on"), &gorm.Config{
+		Logger: logger.Default.LogMode(logger.Silent),
+	})
+	if err != nil {
+		t.Fatalf("Failed to open database: %v", err)
+	}
+
+	// Every connection to :memory: gets its own database, so pin the pool to one
+	sqlDB, err := db.DB()
+	if err != nil {
+		t.Fatalf("Failed to get database handle: %v", err)
+	}
+	sqlDB.SetMaxOpenConns(1)
+	t.Cleanup(func() { sqlDB.Close() })
+	return db
+}
+
+// appliedVersions returns the versions reported as applied by Status
+func appliedVersions(t *testing.T, db *gorm.DB) []int64 {
+	t.Helper()
+	status, err := Status(db)
+	if err != nil {
+		t.Fatalf("Failed to read migration status: %v", err)
+	}
+	versions := make([]int64, 0, len(status))
+	for _, s := range status {
+		if s.Applied {
+			versions = append(versions, s.Version)
+		}
+	}
+	return versions
+}
+
+func TestSQLiteVersionedMigrations(t *testing.T) {
+	db := newTestDB(t)
+	latest := LatestVersion()
+
+	if versions := appliedVersions(t, db); len(versions) != 0 {
+		t.Errorf("Expected no applied migrations on an empty database, got %v", versions)
+	}
+
+	if err := Up(db); err != nil {
+		t.Fatalf("Up failed: %v", err)
+	}
+	if versions := appliedVersions(t, db); len(versions) != int(latest) {
+		t.Errorf("Expected all %d migrations to be applied, got %v", latest, versions)
+	}
+	// Applying again is a no-op
+	if err := Migrate(db); err != nil {
+		t.Fatalf("Migrate failed on a migrated database: %v", err)
+	}
+
+	if err := Down(db); err != nil {
+		t.Fatalf("Down failed: %v", err)
+	}
+	if versions := appliedVersions(t, db); len(versions) != int(latest)-1 {
+		t.Errorf("Expected the latest migration to be reverted, got %v", versions)
+	}
+	if db.Migrator().HasColumn(&models.PolicySubjectRel{}, "Ordinal") {
+		t.Error("Expected the ordinal column to be dropped")
+	}
+
+	if err := To(db, latest); err != nil {
+		t.Fatalf("To(%d) failed: %v", latest, err)
+	}
+	if !db.Migrator().HasColumn(&models.PolicySubjectRel{}, "Ordinal") {
+		t.Error("Expected the ordinal column to be added back")
+	}
+
+	if err := To(db, 0); err != nil {
+		t.Fatalf("To(0) failed: %v", err)
+	}
+	if db.Migrator().HasTable(&models.Policy{}) {
+		t.Error("Expected all tables to be dropped")
+	}
+	// Nothing is left to revert
+	if err := Down(db); err != nil {
+		t.Fatalf("Down failed without applied migrations: %v", err)
+	}
+
+	if err := To(db, latest+1); !errors.Is(err, ErrUnknownVersion) {
+		t.Errorf("Expected ErrUnknownVersion, got %v", err)
+	}
+}
+
+func TestSQLiteMigrateUntrackedSchema(t *testing.T) {
+	db := newTestDB(t)
+
+	// A schema created by AutoMigrate before migrations were tracked
+	err := db.AutoMigrate(&models.Policy{}, &models.Subject{}, &models.Action{}, &models.Resource{},
+		&models.PolicySubjectRel{}, &models.PolicyActionRel{}, &models.PolicyResourceRel{})
+	if err != nil {
+		t.Fatalf("Failed to create schema: %v", err)
+	}
+
+	if err := Migrate(db); err != nil {
+		t.Fatalf("Migrate failed on an untracked schema: %v", err)
+	}
+	if versions := appliedVersions(t, db); len(versions) != int(LatestVersion()) {
+		t.Errorf("Expected all migrations to be tracked, got %v", versions)
+	}
+}
+
+// tableColumns returns the column names of the tables created by migration 1
+func tableColumns(t *testing.T, db *gorm.DB) map[string][]string {
+	t.Helper()
+	columns := make(map[string][]string)
+	for _, table := range []string{models.TableNamePolicy, models.TableNameSubject, models.TableNamePolicySubjectRel} {
+		types, err := db.Migrator().ColumnTypes(table)
+		if err != nil {
+			t.Fatalf("Failed to read the columns of %s: %v", table, err)
+		}
+		for _, column := range types {
+			columns[table] = append(columns[table], column.Name())
+		}
+		sort.Strings(columns[table])
+	}
+	return columns
+}
+
+func TestSQLiteMigrationV1Schema(t *testing.T) {
+	db := newTestDB(t)
+	if err := To(db, 1); err != nil {
+		t.Fatalf("To(1) failed: %v", err)
+	}
+
+	// Migration 1 creates the schema without the columns of migration 2
+	if db.Migrator().HasColumn(models.TableNamePolicy, "start_delimiter") {
+		t.Error("Expected migration 1 not to create the start_delimiter column")
+	}
+	if db.Migrator().HasColumn(models.TableNamePolicySubjectRel, "ordinal") {
+		t.Error("Expected migration 1 not to create the ordinal column")
+	}
+	v1 := tableColumns(t, db)
+
+	// Reverting to version 1 restores the same schema
+	if err := Migrate(db); err != nil {
+		t.Fatalf("Migrate failed: %v", err)
+	}
+	if err := To(db, 1); err != nil {
+		t.Fatalf("To(1) failed: %v", err)
+	}
+	if reverted := tableColumns(t, db); !reflect.DeepEqual(reverted, v1) {
+		t.Errorf("Expected the columns %v after reverting to version 1, got %v", v1, reverted)
+	}
+}
+
+func TestSQLiteMigrationStatus(t *testing.T) {
+	db := newTestDB(t)
+	if err := To(db, 1); err != nil {
+		t.Fatalf("To(1) failed: %v", err)
+	}
+
+	status, err := Status(db)
+	if err != nil {
+		t.Fatalf("Status failed: %v", err)
+	}
+	if len(status) != len(steps) {
+		t.Fatalf("Expected %d migrations, got %d", len(steps), len(status))
+	}
+	for i, s := range status {
+		if s.Version != steps[i].Version || s.Name != steps[i].Name {
+			t.Errorf("Expected migration %d %s, got %d %s", steps[i].Version, steps[i].Name, s.Version, s.Name)
+		}
+		if applied := s.Version <= 1; s.Applied != applied || s.AppliedAt.IsZero() == applied {
+			t.Errorf("Expected migration %d to be applied: %v, got %+v", s.Version, applied, s)
+		}
+	}
+}
+
+func TestSQLiteWithLock(t *testing.T) {
+	db := newTestDB(t)
+	o := newOptions(nil)
+
+	err := withLock(db, o, func(conn *gorm.DB) error {
+		return conn.Migrator().CreateTable(&schemaMigration{})
+	})
+	if err != nil {
+		t.Fatalf("withLock failed: %v", err)
+	}
+	if !db.Migrator().HasTable(&schemaMigration{}) {
+		t.Error("Expected the statements run under the lock to be applied")
+	}
+
+	failure := errors.New("failure")
+	if err := withLock(db, o, func(*gorm.DB) error { return failure }); !errors.Is(err, failure) {
+		t.Errorf("Expected the error of fn, got %v", err)
+	}
+	// The lock is released after a failure
+	if err := withLock(db, o, func(*gorm.DB) error { return nil }); err != nil {
+		t.Errorf("Expected the lock to be available again, got %v", err)
+	}
+}
+
+// registerAdvisoryLockDriver registers a SQLite driver providing the PostgreSQL
+// advisory lock functions, held per connection like PostgreSQL session locks
+var registerAdvisoryLockDriver = sync.OnceFunc(func() {
+	var mu sync.Mutex
+	owners := make(map[int64]*sqlite3.SQLiteConn)
+	sql.Register("sqlite3_advisory_lock", &sqlite3.SQLiteDriver{
+		ConnectHook: func(conn *sqlite3.SQLiteConn) error {
+			err := conn.RegisterFunc("pg_try_advisory_lock", func(id int64) bool {
+				mu.Lock()
+				defer mu.Unlock()
+				if owner, held := owners[id]; held && owner != conn {
+					return false
+				}
+				owners[id] = conn
+				return true
+			}, false)
+			if err != nil {
+				return err
+			}
+			return conn.RegisterFunc("pg_advisory_unlock", func(id int64) bool {
+				mu.Lock()
+				defer mu.Unlock()
+				if owners[id] != conn {
+					return false
+				}
+				delete(owners, id)
+				return true
+			}, false)
+		},
+	})
+})
+
+func TestPostgresWithLockTimeout(t *testing.T) {
+	registerAdvisoryLockDriver()
+	// The postgres dialector on SQLite, so no PostgreSQL server is required
+	db, err := gorm.Open(postgres.New(postgres.Config{DriverName: "sqlite3_advisory_lock", DSN: ":memory:"}), &gorm.Config{
+		Logger: logger.Default.LogMode(logger.Silent),
+	})
+	if err != nil {
+		t.Fatalf("Failed to open database: %v", err)
+	}
+	sqlDB, err := db.DB()
+	if err != nil {
+		t.Fatalf("Failed to get database handle: %v", err)
+	}
+	t.Cleanup(func() { sqlDB.Close() })
+
+	defer func(timeout, interval time.Duration) {
+		lockTimeout, lockRetryInterval = timeout, interval
+	}(lockTimeout, lockRetryInterval)
+	lockTimeout, lockRetryInterval = 100*time.Millisecond, 10*time.Millisecond
+
+	// A second connection holds the lock, e.g. a hung migrator
+	ctx := context.Background()
+	holder, err := sqlDB.Conn(ctx)
+	if err != nil {
+		t.Fatalf("Failed to open a second connection: %v", err)
+	}
+	defer holder.Close()
+	if _, err := holder.ExecContext(ctx, "SELECT pg_try_advisory_lock($1)", advisoryLockID); err != nil {
+		t.Fatalf("Failed to take the lock: %v", err)
+	}
+
+	ran := false
+	err = withLock(db, newOptions(nil), func(*gorm.DB) error {
+		ran = true
+		return nil
+	})
+	if !errors.Is(err, ErrLockTimeout) {
+		t.Errorf("Expected ErrLockTimeout while the lock is held, got %v", err)
+	}
+	if ran {
+		t.Error("Expected fn not to run without the lock")
+	}
+
+	if _, err := holder.ExecContext(ctx, "SELECT pg_advisory_unlock($1)", advisoryLockID); err != nil {
+		t.Fatalf("Failed to release the lock: %v", err)
+	}
+	if err := withLock(db, newOptions(nil), func(*gorm.DB) error {
+		ran = true
+		return nil
+	}); err != nil || !ran {
+		t.Errorf("Expected fn to run once the lock is released, got %v", err)
+	}
+
+	// withLock released the lock again
+	var acquired bool
+	if err := holder.QueryRowContext(ctx, "SELECT pg_try_advisory_lock($1)", advisoryLockID).Scan(&acquired); err != nil || !acquired {
+		t.Errorf("Expected the lock to be released after fn, got %v, %v", acquired, err)
+	}
+}
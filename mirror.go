@@ -0,0 +1,407 @@
+package ladonsqlmanager
+
+import (
+	"context"
+	"regexp"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/ladonsqlmanager/models"
+	"github.com/ory/ladon"
+	"github.com/pkg/errors"
+)
+
+// ErrMirrorNotLoaded is returned by the reads of a MemoryMirror before its first Refresh
+var ErrMirrorNotLoaded = errors.New("memory mirror has not been loaded")
+
+// MirrorConfig holds configuration options for MemoryMirror
+type MirrorConfig struct {
+	// RefreshInterval is how often Run reloads all policies. Zero disables periodic
+	// refreshes, leaving only change-driven ones.
+	RefreshInterval time.Duration
+}
+
+// DefaultMirrorConfig returns a default mirror configuration
+func DefaultMirrorConfig() MirrorConfig {
+	return MirrorConfig{
+		RefreshInterval: 5 * time.Minute,
+	}
+}
+
+// MemoryMirror keeps all policies of a SQLManager in memory with precompiled
+// patterns and serves reads without touching the database. It implements
+// ladon.Manager, writes go through to the SQLManager.
+//
+// The mirror is loaded by Refresh. Run keeps it up to date, periodically and, when
+// the mirror is the handler of a ChangeListener, whenever a policy changes.
+type MemoryMirror struct {
+	manager *SQLManager
+	config  MirrorConfig
+	matcher *templateMatcher
+
+	mu       sync.RWMutex
+	snapshot *mirrorSnapshot
+	// refreshMu orders refreshes so a slower load never replaces a newer one
+	refreshMu sync.Mutex
+
+	pendingMu  sync.Mutex
+	pendingIDs map[string]bool
+	pendingAll bool
+	pending    chan struct{}
+}
+
+// mirrorSnapshot is an immutable set of mirrored policies
+type mirrorSnapshot struct {
+	// policies are sorted by ID
+	policies []*mirroredPolicy
+	byID     map[string]*mirroredPolicy
+}
+
+// mirroredPolicy is a policy with the patterns of its entities
+type mirroredPolicy struct {
+	policy    ladon.Policy
+	subjects  []mirroredEntity
+	actions   []mirroredEntity
+	resources []mirroredEntity
+}
+
+// mirroredEntity matches values like an entity row. pattern is nil for entities
+// without regex, which match their template exactly.
+type mirroredEntity struct {
+	template string
+	pattern  *regexp.Regexp
+}
+
+// NewMemoryMirror creates an empty mirror of manager. Call Refresh to load it.
+func NewMemoryMirror(manager *SQLManager, config MirrorConfig) *MemoryMirror {
+	return &MemoryMirror{
+		manager:    manager,
+		config:     config,
+		matcher:    newTemplateMatcher(),
+		pendingIDs: make(map[string]bool),
+		pending:    make(chan struct{}, 1),
+	}
+}
+
+// Refresh reloads all policies from the database
+func (m *MemoryMirror) Refresh(ctx context.Context) error {
+	m.refreshMu.Lock()
+	defer m.refreshMu.Unlock()
+
+	policies, err := m.manager.findPolicyModels(ctx, nil)
+	if err != nil {
+		return err
+	}
+
+	snapshot := &mirrorSnapshot{
+		policies: make([]*mirroredPolicy, 0, len(policies)),
+		byID:     make(map[string]*mirroredPolicy, len(policies)),
+	}
+	for _, policy := range policies {
+		mirrored, err := m.mirrorPolicy(policy)
+		if err != nil {
+			return err
+		}
+		snapshot.policies = append(snapshot.policies, mirrored)
+		snapshot.byID[policy.ID] = mirrored
+	}
+
+	m.mu.Lock()
+	m.snapshot = snapshot
+	m.mu.Unlock()
+	return nil
+}
+
+// refreshPolicies reloads the given policies and keeps all others
+func (m *MemoryMirror) refreshPolicies(ctx context.Context, ids []string) error {
+	m.refreshMu.Lock()
+	defer m.refreshMu.Unlock()
+
+	policies, err := m.manager.findPolicyModels(ctx, ids)
+	if err != nil {
+		return err
+	}
+
+	loaded := make(map[string]*mirroredPolicy, len(policies))
+	for _, policy := range policies {
+		mirrored, err := m.mirrorPolicy(policy)
+		if err != nil {
+			return err
+		}
+		loaded[policy.ID] = mirrored
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.snapshot == nil {
+		// Nothing to patch, the first Refresh loads everything
+		return nil
+	}
+
+	byID := make(map[string]*mirroredPolicy, len(m.snapshot.byID)+len(loaded))
+	for id, mirrored := range m.snapshot.byID {
+		byID[id] = mirrored
+	}
+	for _, id := range ids {
+		if mirrored, ok := loaded[id]; ok {
+			byID[id] = mirrored
+		} else {
+			delete(byID, id)
+		}
+	}
+
+	snapshot := &mirrorSnapshot{
+		policies: make([]*mirroredPolicy, 0, len(byID)),
+		byID:     byID,
+	}
+	for _, mirrored := range byID {
+		snapshot.policies = append(snapshot.policies, mirrored)
+	}
+	sort.Slice(snapshot.policies, func(i, j int) bool {
+		return snapshot.policies[i].policy.GetID() < snapshot.policies[j].policy.GetID()
+	})
+	m.snapshot = snapshot
+	return nil
+}
+
+// Run keeps the mirror up to date until ctx is done. It reloads everything every
+// RefreshInterval and the policies reported to PolicyChanged as they come in.
+// Failed refreshes are retried with the next change or interval.
+func (m *MemoryMirror) Run(ctx context.Context) error {
+	var tick <-chan time.Time
+	if m.config.RefreshInterval > 0 {
+		ticker := time.NewTicker(m.config.RefreshInterval)
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-tick:
+			m.markAll()
+		case <-m.pending:
+		}
+
+		m.pendingMu.Lock()
+		all, ids := m.pendingAll, m.pendingIDs
+		m.pendingAll, m.pendingIDs = false, make(map[string]bool)
+		m.pendingMu.Unlock()
+
+		var err error
+		if all {
+			err = m.Refresh(ctx)
+		} else if len(ids) > 0 {
+			changed := make([]string, 0, len(ids))
+			for id := range ids {
+				changed = append(changed, id)
+			}
+			err = m.refreshPolicies(ctx, changed)
+		}
+		if err != nil {
+			m.manager.logger.Warn("Failed to refresh memory mirror", "error", err)
+			m.pendingMu.Lock()
+			m.pendingAll = true
+			m.pendingMu.Unlock()
+		}
+	}
+}
+
+// Listening implements ChangeHandler by scheduling a full refresh
+func (m *MemoryMirror) Listening() {
+	m.markAll()
+}
+
+// PolicyChanged implements ChangeHandler by scheduling a refresh of the policy.
+// The refresh happens in Run, so the handler never blocks the transport.
+func (m *MemoryMirror) PolicyChanged(policyID string) {
+	m.pendingMu.Lock()
+	m.pendingIDs[policyID] = true
+	m.pendingMu.Unlock()
+	m.signal()
+}
+
+func (m *MemoryMirror) markAll() {
+	m.pendingMu.Lock()
+	m.pendingAll = true
+	m.pendingMu.Unlock()
+	m.signal()
+}
+
+func (m *MemoryMirror) signal() {
+	select {
+	case m.pending <- struct{}{}:
+	default:
+	}
+}
+
+// Create inserts a new policy and adds it to the mirror
+func (m *MemoryMirror) Create(ctx context.Context, policy ladon.Policy) error {
+	if err := m.manager.Create(ctx, policy); err != nil {
+		return err
+	}
+	return m.refreshPolicies(ctx, []string{policy.GetID()})
+}
+
+// Update updates a policy and replaces it in the mirror
+func (m *MemoryMirror) Update(ctx context.Context, policy ladon.Policy) error {
+	if err := m.manager.Update(ctx, policy); err != nil {
+		return err
+	}
+	return m.refreshPolicies(ctx, []string{policy.GetID()})
+}
+
+// Delete removes a policy and drops it from the mirror
+func (m *MemoryMirror) Delete(ctx context.Context, id string) error {
+	if err := m.manager.Delete(ctx, id); err != nil {
+		return err
+	}
+	return m.refreshPolicies(ctx, []string{id})
+}
+
+// Get retrieves a policy from the mirror
+func (m *MemoryMirror) Get(ctx context.Context, id string) (ladon.Policy, error) {
+	snapshot, err := m.current()
+	if err != nil {
+		return nil, err
+	}
+	mirrored, ok := snapshot.byID[id]
+	if !ok {
+		return nil, errors.WithStack(ErrPolicyNotFound)
+	}
+	return mirrored.policy, nil
+}
+
+// GetAll returns the mirrored policies ordered by ID
+func (m *MemoryMirror) GetAll(ctx context.Context, limit, offset int64) (ladon.Policies, error) {
+	snapshot, err := m.current()
+	if err != nil {
+		return nil, err
+	}
+
+	total := int64(len(snapshot.policies))
+	if offset > total {
+		offset = total
+	}
+	end := total
+	if limit >= 0 && offset+limit < total {
+		end = offset + limit
+	}
+
+	policies := make(ladon.Policies, 0, end-offset)
+	for _, mirrored := range snapshot.policies[offset:end] {
+		policies = append(policies, mirrored.policy)
+	}
+	return policies, nil
+}
+
+// FindRequestCandidates returns the mirrored policies whose subjects match the
+// request. With StrictCandidates the action and resource have to match as well.
+func (m *MemoryMirror) FindRequestCandidates(ctx context.Context, r *ladon.Request) (ladon.Policies, error) {
+	strict := m.manager.config.StrictCandidates
+	return m.find(func(policy *mirroredPolicy) bool {
+		if !matchesAnyEntity(policy.subjects, r.Subject) {
+			return false
+		}
+		return !strict || (matchesAnyEntity(policy.actions, r.Action) && matchesAnyEntity(policy.resources, r.Resource))
+	})
+}
+
+// FindPoliciesForSubject returns the mirrored policies that match the subject
+func (m *MemoryMirror) FindPoliciesForSubject(ctx context.Context, subject string) (ladon.Policies, error) {
+	return m.find(func(policy *mirroredPolicy) bool {
+		return matchesAnyEntity(policy.subjects, subject)
+	})
+}
+
+// FindPoliciesForResource returns the mirrored policies that match the resource
+func (m *MemoryMirror) FindPoliciesForResource(ctx context.Context, resource string) (ladon.Policies, error) {
+	return m.find(func(policy *mirroredPolicy) bool {
+		return matchesAnyEntity(policy.resources, resource)
+	})
+}
+
+// FindPoliciesForAction returns the mirrored policies that match the action
+func (m *MemoryMirror) FindPoliciesForAction(ctx context.Context, action string) (ladon.Policies, error) {
+	return m.find(func(policy *mirroredPolicy) bool {
+		return matchesAnyEntity(policy.actions, action)
+	})
+}
+
+// find returns the mirrored policies accepted by match
+func (m *MemoryMirror) find(match func(*mirroredPolicy) bool) (ladon.Policies, error) {
+	snapshot, err := m.current()
+	if err != nil {
+		return nil, err
+	}
+
+	policies := make(ladon.Policies, 0)
+	for _, mirrored := range snapshot.policies {
+		if match(mirrored) {
+			policies = append(policies, mirrored.policy)
+		}
+	}
+	return policies, nil
+}
+
+// current returns the current snapshot
+func (m *MemoryMirror) current() (*mirrorSnapshot, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	if m.snapshot == nil {
+		return nil, ErrMirrorNotLoaded
+	}
+	return m.snapshot, nil
+}
+
+// mirrorPolicy converts a policy model and precompiles the patterns of its entities
+func (m *MemoryMirror) mirrorPolicy(policy models.Policy) (*mirroredPolicy, error) {
+	converted, err := m.manager.convertPolicyToLadon(policy)
+	if err != nil {
+		return nil, err
+	}
+
+	mirrored := &mirroredPolicy{policy: converted}
+	if mirrored.subjects, err = m.mirrorEntities(subjectEntities(policy)); err != nil {
+		return nil, err
+	}
+	if mirrored.actions, err = m.mirrorEntities(actionEntities(policy)); err != nil {
+		return nil, err
+	}
+	if mirrored.resources, err = m.mirrorEntities(resourceEntities(policy)); err != nil {
+		return nil, err
+	}
+	return mirrored, nil
+}
+
+// mirrorEntities compiles the stored patterns of regex entities
+func (m *MemoryMirror) mirrorEntities(entities []models.BaseEntity) ([]mirroredEntity, error) {
+	mirrored := make([]mirroredEntity, len(entities))
+	for i, entity := range entities {
+		mirrored[i].template = entity.Template
+		if entity.HasRegex {
+			pattern, err := m.matcher.pattern(entity.Compiled)
+			if err != nil {
+				return nil, err
+			}
+			mirrored[i].pattern = pattern
+		}
+	}
+	return mirrored, nil
+}
+
+// matchesAnyEntity reports whether value matches at least one of the entities
+func matchesAnyEntity(entities []mirroredEntity, value string) bool {
+	for _, entity := range entities {
+		if entity.pattern == nil {
+			if entity.template == value {
+				return true
+			}
+		} else if entity.pattern.MatchString(value) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,187 @@
+package ladonsqlmanager
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/ory/ladon"
+	"github.com/pkg/errors"
+)
+
+var _ ladon.Manager = (*MemoryMirror)(nil)
+
+// eventually polls condition until it holds or a second passed
+func eventually(t *testing.T, message string, condition func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for !condition() {
+		if time.Now().After(deadline) {
+			t.Fatal(message)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestSQLiteMemoryMirror(t *testing.T) {
+	metrics := NewInMemoryMetrics()
+	config := DefaultConfig()
+	config.Metrics = metrics
+	manager := newTestManagerWithConfig(t, config)
+	mirror := NewMemoryMirror(manager, DefaultMirrorConfig())
+	ctx := context.Background()
+
+	if _, err := mirror.Get(ctx, "1"); !errors.Is(err, ErrMirrorNotLoaded) {
+		t.Errorf("Expected ErrMirrorNotLoaded before Refresh, got %v", err)
+	}
+
+	createTestPolicies(t, manager,
+		&ladon.DefaultPolicy{
+			ID:          "1",
+			Description: "users can read articles",
+			Subjects:    []string{"user:<[0-9]+>"},
+			Effect:      ladon.AllowAccess,
+			Resources:   []string{"article:<[0-9]+>"},
+			Actions:     []string{"read"},
+		},
+		&ladon.DefaultPolicy{
+			ID:          "2",
+			Description: "admins can delete articles",
+			Subjects:    []string{"admin"},
+			Effect:      ladon.AllowAccess,
+			Resources:   []string{"article:<.*>"},
+			Actions:     []string{"delete"},
+		},
+	)
+	if err := mirror.Refresh(ctx); err != nil {
+		t.Fatalf("Refresh failed: %v", err)
+	}
+	metrics.Reset()
+
+	policies, err := mirror.FindRequestCandidates(ctx, &ladon.Request{Subject: "user:7"})
+	if err != nil {
+		t.Fatalf("FindRequestCandidates failed: %v", err)
+	}
+	if len(policies) != 1 || policies[0].GetID() != "1" {
+		t.Errorf("Expected policy 1, got %v", policyIDs(policies))
+	}
+	if policies, _ := mirror.FindRequestCandidates(ctx, &ladon.Request{Subject: "user:x"}); len(policies) != 0 {
+		t.Errorf("Expected no candidates for a non-matching subject, got %v", policyIDs(policies))
+	}
+	if policies, _ := mirror.FindPoliciesForResource(ctx, "article:abc"); len(policies) != 1 || policies[0].GetID() != "2" {
+		t.Errorf("Expected policy 2 for resource, got %v", policyIDs(policies))
+	}
+	if policies, _ := mirror.FindPoliciesForAction(ctx, "read"); len(policies) != 1 || policies[0].GetID() != "1" {
+		t.Errorf("Expected policy 1 for action, got %v", policyIDs(policies))
+	}
+	if policies, _ := mirror.FindPoliciesForSubject(ctx, "admin"); len(policies) != 1 || policies[0].GetID() != "2" {
+		t.Errorf("Expected policy 2 for subject, got %v", policyIDs(policies))
+	}
+
+	warden := &ladon.Ladon{Manager: mirror}
+	if err := warden.IsAllowed(ctx, &ladon.Request{Subject: "user:1", Resource: "article:2", Action: "read"}); err != nil {
+		t.Errorf("Expected request to be allowed, got %v", err)
+	}
+	if err := warden.IsAllowed(ctx, &ladon.Request{Subject: "user:1", Resource: "article:2", Action: "delete"}); err == nil {
+		t.Error("Expected request to be denied")
+	}
+
+	if len(metrics.Snapshot()) != 0 {
+		t.Errorf("Expected reads not to touch the database, got %v", metrics.Snapshot())
+	}
+
+	all, _ := mirror.GetAll(ctx, 1, 1)
+	if len(all) != 1 || all[0].GetID() != "2" {
+		t.Errorf("Expected the second policy, got %v", policyIDs(all))
+	}
+	if all, _ := mirror.GetAll(ctx, 10, 5); len(all) != 0 {
+		t.Errorf("Expected no policies past the end, got %v", policyIDs(all))
+	}
+
+	// Writes through the mirror are visible right away
+	if err := mirror.Update(ctx, &ladon.DefaultPolicy{
+		ID:          "1",
+		Description: "users can read and write articles",
+		Subjects:    []string{"user:<[0-9]+>"},
+		Effect:      ladon.AllowAccess,
+		Resources:   []string{"article:<[0-9]+>"},
+		Actions:     []string{"read", "write"},
+	}); err != nil {
+		t.Fatalf("Update failed: %v", err)
+	}
+	if err := warden.IsAllowed(ctx, &ladon.Request{Subject: "user:1", Resource: "article:2", Action: "write"}); err != nil {
+		t.Errorf("Expected updated policy to allow writes, got %v", err)
+	}
+	if err := mirror.Delete(ctx, "2"); err != nil {
+		t.Fatalf("Delete failed: %v", err)
+	}
+	if _, err := mirror.Get(ctx, "2"); err == nil {
+		t.Error("Expected deleted policy to be gone")
+	}
+	if all, _ := mirror.GetAll(ctx, 10, 0); len(all) != 1 {
+		t.Errorf("Expected 1 policy, got %v", policyIDs(all))
+	}
+}
+
+func TestSQLiteMemoryMirrorChanges(t *testing.T) {
+	transport := NewInProcessTransport()
+	config := DefaultConfig()
+	config.ChangeTransport = transport
+	writer := newTestManagerWithConfig(t, config)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	mirror := NewMemoryMirror(NewWithConfig(writer.db, "sqlite", DefaultConfig()), MirrorConfig{})
+	if err := mirror.Refresh(ctx); err != nil {
+		t.Fatalf("Refresh failed: %v", err)
+	}
+	go mirror.Run(ctx)
+	go NewChangeListener(transport, mirror).Run(ctx)
+	eventually(t, "Timed out waiting for the subscription", func() bool { return transport.Subscribers() == 1 })
+
+	createTestPolicies(t, writer, &ladon.DefaultPolicy{
+		ID:          "1",
+		Description: "users can read articles",
+		Subjects:    []string{"user"},
+		Effect:      ladon.AllowAccess,
+		Resources:   []string{"article:1"},
+		Actions:     []string{"read"},
+	})
+	eventually(t, "Expected the mirror to pick up the new policy", func() bool {
+		_, err := mirror.Get(ctx, "1")
+		return err == nil
+	})
+
+	if err := writer.Delete(ctx, "1"); err != nil {
+		t.Fatalf("Delete failed: %v", err)
+	}
+	eventually(t, "Expected the mirror to drop the deleted policy", func() bool {
+		_, err := mirror.Get(ctx, "1")
+		return err != nil
+	})
+}
+
+func TestSQLiteMemoryMirrorPeriodicRefresh(t *testing.T) {
+	manager := newTestManager(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	mirror := NewMemoryMirror(manager, MirrorConfig{RefreshInterval: 10 * time.Millisecond})
+	if err := mirror.Refresh(ctx); err != nil {
+		t.Fatalf("Refresh failed: %v", err)
+	}
+	go mirror.Run(ctx)
+
+	createTestPolicies(t, manager, &ladon.DefaultPolicy{
+		ID:          "1",
+		Description: "users can read articles",
+		Subjects:    []string{"user"},
+		Effect:      ladon.AllowAccess,
+		Resources:   []string{"article:1"},
+		Actions:     []string{"read"},
+	})
+	eventually(t, "Expected the periodic refresh to pick up the new policy", func() bool {
+		policies, err := mirror.FindRequestCandidates(ctx, &ladon.Request{Subject: "user"})
+		return err == nil && len(policies) == 1
+	})
+}
@@ -120,22 +120,22 @@ type BaseEntity struct {
 // Validate validates the base entity fields
 func (b *BaseEntity) Validate() error {
 	if b.ID == "" {
-		return errors.New("entity ID cannot be empty")
+		return &ValidationError{Field: "id", Reason: "cannot be empty"}
 	}
 	if len(b.ID) > EntityIDMaxLength {
-		return errors.New("entity ID exceeds maximum length")
+		return &ValidationError{Field: "id", Reason: "exceeds maximum length"}
 	}
 	if b.Compiled == "" {
-		return errors.New("compiled field cannot be empty")
+		return &ValidationError{Field: "compiled", Reason: "cannot be empty"}
 	}
 	if len(b.Compiled) > CompiledMaxLength {
-		return errors.New("compiled field exceeds maximum length")
+		return &ValidationError{Field: "compiled", Reason: "exceeds maximum length"}
 	}
 	if b.Template == "" {
-		return errors.New("template field cannot be empty")
+		return &ValidationError{Field: "template", Reason: "cannot be empty"}
 	}
 	if len(b.Template) > TemplateMaxLength {
-		return errors.New("template field exceeds maximum length")
+		return &ValidationError{Field: "template", Reason: "exceeds maximum length"}
 	}
 	return nil
 }
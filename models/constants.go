@@ -17,7 +17,15 @@ const (
 	TableNamePolicyResourceRel = "ladon_policy_resource_rel"
 )
 
-// Field size constants
+// Template delimiters of ladon.DefaultPolicy, used for policies stored before the
+// delimiters were persisted
+const (
+	DefaultStartDelimiter byte = '<'
+	DefaultEndDelimiter   byte = '>'
+)
+
+// Field size constants. Indexed columns are sized so that every index stays below
+// MySQL's 3072-byte InnoDB key limit with utf8mb4 (4 bytes per character).
 const (
 	PolicyIDMaxLength = 255
 	EntityIDMaxLength = 64
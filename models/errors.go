@@ -0,0 +1,16 @@
+package models
+
+import "fmt"
+
+// ValidationError describes a field that failed validation
+type ValidationError struct {
+	// Field is the column name of the invalid field
+	Field string
+	// Reason describes what is wrong with the field
+	Reason string
+}
+
+// Error implements the error interface
+func (e *ValidationError) Error() string {
+	return fmt.Sprintf("invalid %s: %s", e.Field, e.Reason)
+}
@@ -11,6 +11,12 @@ type Entity interface {
 	TableName() string
 }
 
+// OrderedRelation interface for relations that keep the position of their template
+// within the policy
+type OrderedRelation interface {
+	SetOrdinal(ordinal int)
+}
+
 // PolicyEntity interface for entities that can be associated with policies
 type PolicyEntity interface {
 	Entity
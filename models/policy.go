@@ -1,7 +1,6 @@
 package models
 
 import (
-	"errors"
 	"time"
 
 	"gorm.io/gorm"
@@ -9,14 +8,18 @@ import (
 
 // Policy represents the main policy table
 type Policy struct {
-	ID          string         `gorm:"column:id;type:varchar(255);primaryKey;not null"`
-	Description string         `gorm:"column:description;type:text;not null"`
-	Effect      string         `gorm:"column:effect;type:text;not null;check:effect IN ('allow', 'deny')"`
-	Conditions  JSONText       `gorm:"column:conditions;type:text;not null"`
-	Meta        JSONText       `gorm:"column:meta;type:text"`
-	CreatedAt   time.Time      `gorm:"column:created_at;autoCreateTime"`
-	UpdatedAt   time.Time      `gorm:"column:updated_at;autoUpdateTime"`
-	DeletedAt   gorm.DeletedAt `gorm:"column:deleted_at;index"`
+	ID          string   `gorm:"column:id;type:varchar(255);primaryKey;not null"`
+	Description string   `gorm:"column:description;type:text;not null"`
+	Effect      string   `gorm:"column:effect;type:varchar(16);not null;check:effect IN ('allow', 'deny')"`
+	Conditions  JSONText `gorm:"column:conditions;type:text;not null"`
+	Meta        JSONText `gorm:"column:meta;type:text"`
+	// StartDelimiter and EndDelimiter enclose the regular expressions in the
+	// policy's templates
+	StartDelimiter uint8          `gorm:"column:start_delimiter;not null;default:60"`
+	EndDelimiter   uint8          `gorm:"column:end_delimiter;not null;default:62"`
+	CreatedAt      time.Time      `gorm:"column:created_at;autoCreateTime"`
+	UpdatedAt      time.Time      `gorm:"column:updated_at;autoUpdateTime"`
+	DeletedAt      gorm.DeletedAt `gorm:"column:deleted_at;index"`
 
 	// Relationships
 	Subjects  []Subject  `gorm:"many2many:ladon_policy_subject_rel;foreignKey:ID;joinForeignKey:Policy;References:ID;joinReferences:Subject"`
@@ -32,19 +35,19 @@ func (Policy) TableName() string {
 // Validate validates the policy fields
 func (p *Policy) Validate() error {
 	if p.ID == "" {
-		return errors.New("policy ID cannot be empty")
+		return &ValidationError{Field: "id", Reason: "cannot be empty"}
 	}
 	if len(p.ID) > PolicyIDMaxLength {
-		return errors.New("policy ID exceeds maximum length")
+		return &ValidationError{Field: "id", Reason: "exceeds maximum length"}
 	}
 	if p.Description == "" {
-		return errors.New("policy description cannot be empty")
+		return &ValidationError{Field: "description", Reason: "cannot be empty"}
 	}
 	if p.Effect != EffectAllow && p.Effect != EffectDeny {
-		return errors.New("effect must be 'allow' or 'deny'")
+		return &ValidationError{Field: "effect", Reason: "must be 'allow' or 'deny'"}
 	}
 	if p.Conditions == nil {
-		return errors.New("policy conditions cannot be nil")
+		return &ValidationError{Field: "conditions", Reason: "cannot be nil"}
 	}
 	return nil
 }
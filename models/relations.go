@@ -9,6 +9,8 @@ type PolicySubjectRel struct {
 	Policy    string    `gorm:"column:policy;type:varchar(255);primaryKey;not null"`
 	Subject   string    `gorm:"column:subject;type:varchar(64);primaryKey;not null"`
 	CreatedAt time.Time `gorm:"column:created_at;autoCreateTime"`
+	// Ordinal is the position of the template within the policy
+	Ordinal int `gorm:"column:ordinal;not null;default:0"`
 
 	// Foreign key relationships
 	PolicyRef  Policy  `gorm:"foreignKey:Policy;references:ID;constraint:OnDelete:CASCADE"`
@@ -20,11 +22,18 @@ func (PolicySubjectRel) TableName() string {
 	return TableNamePolicySubjectRel
 }
 
+// SetOrdinal sets the position of the subject within the policy
+func (r *PolicySubjectRel) SetOrdinal(ordinal int) {
+	r.Ordinal = ordinal
+}
+
 // PolicyActionRel represents the policy-action relationship table
 type PolicyActionRel struct {
 	Policy    string    `gorm:"column:policy;type:varchar(255);primaryKey;not null"`
 	Action    string    `gorm:"column:action;type:varchar(64);primaryKey;not null"`
 	CreatedAt time.Time `gorm:"column:created_at;autoCreateTime"`
+	// Ordinal is the position of the template within the policy
+	Ordinal int `gorm:"column:ordinal;not null;default:0"`
 
 	// Foreign key relationships
 	PolicyRef Policy `gorm:"foreignKey:Policy;references:ID;constraint:OnDelete:CASCADE"`
@@ -36,11 +45,18 @@ func (PolicyActionRel) TableName() string {
 	return TableNamePolicyActionRel
 }
 
+// SetOrdinal sets the position of the action within the policy
+func (r *PolicyActionRel) SetOrdinal(ordinal int) {
+	r.Ordinal = ordinal
+}
+
 // PolicyResourceRel represents the policy-resource relationship table
 type PolicyResourceRel struct {
 	Policy    string    `gorm:"column:policy;type:varchar(255);primaryKey;not null"`
 	Resource  string    `gorm:"column:resource;type:varchar(64);primaryKey;not null"`
 	CreatedAt time.Time `gorm:"column:created_at;autoCreateTime"`
+	// Ordinal is the position of the template within the policy
+	Ordinal int `gorm:"column:ordinal;not null;default:0"`
 
 	// Foreign key relationships
 	PolicyRef   Policy   `gorm:"foreignKey:Policy;references:ID;constraint:OnDelete:CASCADE"`
@@ -51,3 +67,8 @@ type PolicyResourceRel struct {
 func (PolicyResourceRel) TableName() string {
 	return TableNamePolicyResourceRel
 }
+
+// SetOrdinal sets the position of the resource within the policy
+func (r *PolicyResourceRel) SetOrdinal(ordinal int) {
+	r.Ordinal = ordinal
+}
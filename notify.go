@@ -0,0 +1,224 @@
+package ladonsqlmanager
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/pkg/errors"
+	"gorm.io/gorm"
+)
+
+// DefaultNotifyChannel is the Postgres channel used by PostgresTransport when no
+// channel is given
+const DefaultNotifyChannel = "ladon_policy_changes"
+
+// ChangeTransport carries the IDs of changed policies between SQLManager instances
+type ChangeTransport interface {
+	// Publish announces a changed policy. It is called with the transaction of the
+	// write, so transports that support it only deliver the change on commit.
+	Publish(tx *gorm.DB, policyID string) error
+	// Subscribe passes changes to handler until ctx is done or the subscription
+	// fails. It calls handler.Listening once changes are being received.
+	Subscribe(ctx context.Context, handler ChangeHandler) error
+}
+
+// ChangeHandler receives the changes of a ChangeTransport
+type ChangeHandler interface {
+	// Listening is called whenever a subscription starts. Changes made before may
+	// have been missed.
+	Listening()
+	// PolicyChanged is called with the ID of a created, updated or deleted policy
+	PolicyChanged(policyID string)
+}
+
+// publishChange announces a changed policy through Config.ChangeTransport
+func (s *SQLManager) publishChange(tx *gorm.DB, id string) error {
+	if s.config.ChangeTransport == nil {
+		return nil
+	}
+	return s.config.ChangeTransport.Publish(tx, id)
+}
+
+// Listening implements ChangeHandler by invalidating the whole cache
+func (c *CachedManager) Listening() {
+	c.InvalidateAll()
+}
+
+// PolicyChanged implements ChangeHandler by invalidating the policy
+func (c *CachedManager) PolicyChanged(policyID string) {
+	c.InvalidatePolicy(policyID)
+}
+
+// ChangeListener keeps a subscription to a ChangeTransport open and passes the
+// changes to a handler, e.g. a CachedManager of another replica
+type ChangeListener struct {
+	transport ChangeTransport
+	handler   ChangeHandler
+
+	// RetryInterval is the initial delay before resubscribing after a failure. It
+	// doubles with every failure in a row up to MaxRetryInterval.
+	RetryInterval    time.Duration
+	MaxRetryInterval time.Duration
+	// Logger receives subscription failures. Nil keeps the listener silent.
+	Logger *slog.Logger
+}
+
+// NewChangeListener creates a listener passing the changes of transport to handler
+func NewChangeListener(transport ChangeTransport, handler ChangeHandler) *ChangeListener {
+	return &ChangeListener{
+		transport:        transport,
+		handler:          handler,
+		RetryInterval:    time.Second,
+		MaxRetryInterval: 30 * time.Second,
+	}
+}
+
+// Run subscribes to the transport and resubscribes after failures until ctx is done
+func (l *ChangeListener) Run(ctx context.Context) error {
+	logger := l.Logger
+	if logger == nil {
+		logger = slog.New(slog.DiscardHandler)
+	}
+
+	delay := l.RetryInterval
+	for {
+		started := time.Now()
+		err := l.transport.Subscribe(ctx, l.handler)
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+
+		// A subscription that was up for a while starts over with a short delay
+		if time.Since(started) > l.MaxRetryInterval {
+			delay = l.RetryInterval
+		}
+		logger.Warn("Policy change subscription failed", "error", err, "retry_in", delay)
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(delay):
+		}
+		if delay *= 2; delay > l.MaxRetryInterval {
+			delay = l.MaxRetryInterval
+		}
+	}
+}
+
+// PostgresTransport is a ChangeTransport using Postgres NOTIFY and LISTEN.
+// Notifications are sent within the write transaction, so they are only delivered
+// when it commits.
+type PostgresTransport struct {
+	dsn     string
+	channel string
+}
+
+// NewPostgresTransport creates a transport on the given channel. dsn is used to
+// open the dedicated connection Subscribe listens on.
+func NewPostgresTransport(dsn, channel string) *PostgresTransport {
+	if channel == "" {
+		channel = DefaultNotifyChannel
+	}
+	return &PostgresTransport{dsn: dsn, channel: channel}
+}
+
+// Publish implements ChangeTransport
+func (p *PostgresTransport) Publish(tx *gorm.DB, policyID string) error {
+	return errors.WithStack(tx.Exec("SELECT pg_notify(?, ?)", p.channel, policyID).Error)
+}
+
+// Subscribe implements ChangeTransport
+func (p *PostgresTransport) Subscribe(ctx context.Context, handler ChangeHandler) error {
+	conn, err := pgx.Connect(ctx, p.dsn)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	defer conn.Close(context.Background())
+
+	if _, err := conn.Exec(ctx, "LISTEN "+pgx.Identifier{p.channel}.Sanitize()); err != nil {
+		return errors.WithStack(err)
+	}
+	handler.Listening()
+
+	for {
+		notification, err := conn.WaitForNotification(ctx)
+		if err != nil {
+			return errors.WithStack(err)
+		}
+		handler.PolicyChanged(notification.Payload)
+	}
+}
+
+// InProcessTransport is a ChangeTransport delivering changes to subscribers in the
+// same process, meant for tests. Unlike PostgresTransport it delivers changes when
+// they are published, even if the transaction is rolled back later.
+type InProcessTransport struct {
+	mu          sync.Mutex
+	nextID      int
+	subscribers map[int]*inProcessSubscriber
+}
+
+type inProcessSubscriber struct {
+	handler ChangeHandler
+	failed  chan struct{}
+}
+
+// NewInProcessTransport creates a transport without subscribers
+func NewInProcessTransport() *InProcessTransport {
+	return &InProcessTransport{subscribers: make(map[int]*inProcessSubscriber)}
+}
+
+// Publish implements ChangeTransport
+func (t *InProcessTransport) Publish(tx *gorm.DB, policyID string) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for _, subscriber := range t.subscribers {
+		subscriber.handler.PolicyChanged(policyID)
+	}
+	return nil
+}
+
+// Subscribe implements ChangeTransport
+func (t *InProcessTransport) Subscribe(ctx context.Context, handler ChangeHandler) error {
+	subscriber := &inProcessSubscriber{handler: handler, failed: make(chan struct{})}
+
+	t.mu.Lock()
+	id := t.nextID
+	t.nextID++
+	t.subscribers[id] = subscriber
+	handler.Listening()
+	t.mu.Unlock()
+
+	defer func() {
+		t.mu.Lock()
+		delete(t.subscribers, id)
+		t.mu.Unlock()
+	}()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-subscriber.failed:
+		return errors.New("subscription dropped")
+	}
+}
+
+// Subscribers returns the number of active subscriptions
+func (t *InProcessTransport) Subscribers() int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return len(t.subscribers)
+}
+
+// Drop ends all active subscriptions with an error, like a lost connection would
+func (t *InProcessTransport) Drop() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for id, subscriber := range t.subscribers {
+		close(subscriber.failed)
+		delete(t.subscribers, id)
+	}
+}
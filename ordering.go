@@ -0,0 +1,196 @@
+package ladonsqlmanager
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/ladonsqlmanager/models"
+	"github.com/ory/ladon"
+	"github.com/pkg/errors"
+	"gorm.io/gorm"
+)
+
+// DelimitedPolicy is a ladon.DefaultPolicy with custom template delimiters. Get and
+// the other lookups return it for policies created with delimiters other than the
+// default '<' and '>'.
+type DelimitedPolicy struct {
+	ladon.DefaultPolicy
+	StartDelimiter byte
+	EndDelimiter   byte
+}
+
+// delimitersJSON holds the delimiters in the JSON form of a DelimitedPolicy
+type delimitersJSON struct {
+	StartDelimiter string `json:"start_delimiter"`
+	EndDelimiter   string `json:"end_delimiter"`
+}
+
+// MarshalJSON encodes the policy like ladon.DefaultPolicy, with its delimiters as
+// start_delimiter and end_delimiter
+func (p *DelimitedPolicy) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		*ladon.DefaultPolicy
+		delimitersJSON
+	}{
+		DefaultPolicy:  &p.DefaultPolicy,
+		delimitersJSON: delimitersJSON{string(p.StartDelimiter), string(p.EndDelimiter)},
+	})
+}
+
+// UnmarshalJSON decodes a policy encoded by MarshalJSON. Missing delimiters default
+// to '<' and '>'.
+func (p *DelimitedPolicy) UnmarshalJSON(data []byte) error {
+	var delimiters delimitersJSON
+	if err := json.Unmarshal(data, &delimiters); err != nil {
+		return errors.WithStack(err)
+	}
+	if err := p.DefaultPolicy.UnmarshalJSON(data); err != nil {
+		return errors.WithStack(err)
+	}
+
+	p.StartDelimiter, p.EndDelimiter = models.DefaultStartDelimiter, models.DefaultEndDelimiter
+	if delimiters.StartDelimiter == "" && delimiters.EndDelimiter == "" {
+		return nil
+	}
+	if len(delimiters.StartDelimiter) != 1 || len(delimiters.EndDelimiter) != 1 {
+		return errors.Errorf("delimiters of policy %s must be single characters", p.ID)
+	}
+	p.StartDelimiter, p.EndDelimiter = delimiters.StartDelimiter[0], delimiters.EndDelimiter[0]
+	return nil
+}
+
+// GetStartDelimiter returns the delimiter opening a regular expression
+func (p *DelimitedPolicy) GetStartDelimiter() byte {
+	return p.StartDelimiter
+}
+
+// GetEndDelimiter returns the delimiter closing a regular expression
+func (p *DelimitedPolicy) GetEndDelimiter() byte {
+	return p.EndDelimiter
+}
+
+// relationTables lists the relation table, entity column and entity table of every
+// entity type
+var relationTables = []struct {
+	itemType    string
+	table       string
+	column      string
+	entityTable string
+}{
+	{itemTypeSubject, models.TableNamePolicySubjectRel, "subject", models.TableNameSubject},
+	{itemTypeAction, models.TableNamePolicyActionRel, "action", models.TableNameAction},
+	{itemTypeResource, models.TableNamePolicyResourceRel, "resource", models.TableNameResource},
+}
+
+// checkStoredEntities rejects the built entities whose stored rows were compiled
+// with other delimiters. Entities are keyed by their template alone and shared
+// between policies, so a template reads differently with other delimiters.
+func (s *SQLManager) checkStoredEntities(items []policyItems, policyID string, tx *gorm.DB) error {
+	for _, rel := range relationTables {
+		built := make(map[string]models.BaseEntity)
+		ids := make([]string, 0)
+		for _, item := range items {
+			if item.itemType != rel.itemType {
+				continue
+			}
+			for _, entity := range item.entities {
+				built[entity.ID] = entity
+				ids = append(ids, entity.ID)
+			}
+		}
+		if len(ids) == 0 {
+			continue
+		}
+
+		var stored []models.BaseEntity
+		query := fmt.Sprintf("SELECT id, has_regex, compiled, template FROM %s WHERE id IN ?", rel.entityTable)
+		if err := tx.Raw(query, ids).Scan(&stored).Error; err != nil {
+			return errors.WithStack(err)
+		}
+		for _, entity := range stored {
+			if expected := built[entity.ID]; entity.Compiled != expected.Compiled || entity.HasRegex != expected.HasRegex {
+				return errors.WithStack(&TemplateError{PolicyID: policyID, Type: rel.itemType, Template: expected.Template, Err: ErrTemplateConflict})
+			}
+		}
+	}
+	return nil
+}
+
+// relationKey identifies the relation between a policy and an entity
+type relationKey struct {
+	itemType string
+	policyID string
+	entityID string
+}
+
+// loadOrdinals loads the template positions stored on the relations of the given
+// policies, or of all policies when ids is nil
+func (s *SQLManager) loadOrdinals(tx *gorm.DB, ids []string) (map[relationKey]int, error) {
+	ordinals := make(map[relationKey]int)
+	if ids != nil && len(ids) == 0 {
+		return ordinals, nil
+	}
+
+	parts := make([]string, 0, len(relationTables))
+	args := make([]interface{}, 0, len(relationTables))
+	for _, rel := range relationTables {
+		part := fmt.Sprintf("SELECT '%s' AS item_type, policy, %s AS entity, ordinal FROM %s", rel.itemType, rel.column, rel.table)
+		if ids != nil {
+			part += " WHERE policy IN ?"
+			args = append(args, ids)
+		}
+		parts = append(parts, part)
+	}
+
+	var rows []struct {
+		ItemType string
+		Policy   string
+		Entity   string
+		Ordinal  int
+	}
+	if err := tx.Raw(strings.Join(parts, " UNION ALL "), args...).Scan(&rows).Error; err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	for _, row := range rows {
+		ordinals[relationKey{row.ItemType, row.Policy, row.Entity}] = row.Ordinal
+	}
+	return ordinals, nil
+}
+
+// orderPolicyItems sorts the preloaded subjects, actions and resources of the
+// policies into the order their templates were given in
+func orderPolicyItems(policies []models.Policy, ordinals map[relationKey]int) {
+	for i := range policies {
+		policy := &policies[i]
+		sort.SliceStable(policy.Subjects, func(a, b int) bool {
+			return ordinals[relationKey{itemTypeSubject, policy.ID, policy.Subjects[a].ID}] <
+				ordinals[relationKey{itemTypeSubject, policy.ID, policy.Subjects[b].ID}]
+		})
+		sort.SliceStable(policy.Actions, func(a, b int) bool {
+			return ordinals[relationKey{itemTypeAction, policy.ID, policy.Actions[a].ID}] <
+				ordinals[relationKey{itemTypeAction, policy.ID, policy.Actions[b].ID}]
+		})
+		sort.SliceStable(policy.Resources, func(a, b int) bool {
+			return ordinals[relationKey{itemTypeResource, policy.ID, policy.Resources[a].ID}] <
+				ordinals[relationKey{itemTypeResource, policy.ID, policy.Resources[b].ID}]
+		})
+	}
+}
+
+// sortPolicyItems loads the ordinals of the policies and sorts their entities
+func (s *SQLManager) sortPolicyItems(tx *gorm.DB, policies []models.Policy) error {
+	ids := make([]string, len(policies))
+	for i, policy := range policies {
+		ids[i] = policy.ID
+	}
+
+	ordinals, err := s.loadOrdinals(tx, ids)
+	if err != nil {
+		return err
+	}
+	orderPolicyItems(policies, ordinals)
+	return nil
+}
@@ -1,8 +1,14 @@
 package ladonsqlmanager
 
 import (
+	"fmt"
+	"reflect"
+
 	"github.com/ladonsqlmanager/models"
+	"github.com/pkg/errors"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"gorm.io/gorm/schema"
 )
 
 // RelationStrategy defines the interface for handling different types of policy relations
@@ -11,6 +17,10 @@ type RelationStrategy interface {
 	CreateRelation(policyID, entityID string) interface{}
 	// PersistRelation persists the relation to the database using GORM
 	PersistRelation(relation interface{}, tx *gorm.DB) error
+	// PersistRelations persists relations in batches, skipping the ones that already exist
+	PersistRelations(relations []interface{}, batchSize int, tx *gorm.DB) error
+	// DeleteRelations removes the relations between a policy and the given entities
+	DeleteRelations(policyID string, entityIDs []string, tx *gorm.DB) error
 	// GetRelationType returns the type identifier for this relation
 	GetRelationType() string
 }
@@ -35,6 +45,27 @@ func (s *SubjectRelationStrategy) PersistRelation(relation interface{}, tx *gorm
 	return tx.Where("policy = ? AND subject = ?", rel.Policy, rel.Subject).FirstOrCreate(rel).Error
 }
 
+// PersistRelations persists PolicySubjectRel rows in batches of batchSize
+func (s *SubjectRelationStrategy) PersistRelations(relations []interface{}, batchSize int, tx *gorm.DB) error {
+	rels := make([]*models.PolicySubjectRel, 0, len(relations))
+	for _, relation := range relations {
+		rel, ok := relation.(*models.PolicySubjectRel)
+		if !ok {
+			return ErrInvalidRelationType
+		}
+		rels = append(rels, rel)
+	}
+	return insertIgnoringDuplicates(tx, rels, batchSize)
+}
+
+// DeleteRelations removes the PolicySubjectRel rows linking the policy to the given subjects
+func (s *SubjectRelationStrategy) DeleteRelations(policyID string, entityIDs []string, tx *gorm.DB) error {
+	if len(entityIDs) == 0 {
+		return nil
+	}
+	return tx.Where("policy = ? AND subject IN ?", policyID, entityIDs).Delete(&models.PolicySubjectRel{}).Error
+}
+
 // GetRelationType returns the relation type identifier
 func (s *SubjectRelationStrategy) GetRelationType() string {
 	return itemTypeSubject
@@ -60,6 +91,27 @@ func (a *ActionRelationStrategy) PersistRelation(relation interface{}, tx *gorm.
 	return tx.Where("policy = ? AND action = ?", rel.Policy, rel.Action).FirstOrCreate(rel).Error
 }
 
+// PersistRelations persists PolicyActionRel rows in batches of batchSize
+func (a *ActionRelationStrategy) PersistRelations(relations []interface{}, batchSize int, tx *gorm.DB) error {
+	rels := make([]*models.PolicyActionRel, 0, len(relations))
+	for _, relation := range relations {
+		rel, ok := relation.(*models.PolicyActionRel)
+		if !ok {
+			return ErrInvalidRelationType
+		}
+		rels = append(rels, rel)
+	}
+	return insertIgnoringDuplicates(tx, rels, batchSize)
+}
+
+// DeleteRelations removes the PolicyActionRel rows linking the policy to the given actions
+func (a *ActionRelationStrategy) DeleteRelations(policyID string, entityIDs []string, tx *gorm.DB) error {
+	if len(entityIDs) == 0 {
+		return nil
+	}
+	return tx.Where("policy = ? AND action IN ?", policyID, entityIDs).Delete(&models.PolicyActionRel{}).Error
+}
+
 // GetRelationType returns the relation type identifier
 func (a *ActionRelationStrategy) GetRelationType() string {
 	return itemTypeAction
@@ -85,11 +137,136 @@ func (r *ResourceRelationStrategy) PersistRelation(relation interface{}, tx *gor
 	return tx.Where("policy = ? AND resource = ?", rel.Policy, rel.Resource).FirstOrCreate(rel).Error
 }
 
+// PersistRelations persists PolicyResourceRel rows in batches of batchSize
+func (r *ResourceRelationStrategy) PersistRelations(relations []interface{}, batchSize int, tx *gorm.DB) error {
+	rels := make([]*models.PolicyResourceRel, 0, len(relations))
+	for _, relation := range relations {
+		rel, ok := relation.(*models.PolicyResourceRel)
+		if !ok {
+			return ErrInvalidRelationType
+		}
+		rels = append(rels, rel)
+	}
+	return insertIgnoringDuplicates(tx, rels, batchSize)
+}
+
+// DeleteRelations removes the PolicyResourceRel rows linking the policy to the given resources
+func (r *ResourceRelationStrategy) DeleteRelations(policyID string, entityIDs []string, tx *gorm.DB) error {
+	if len(entityIDs) == 0 {
+		return nil
+	}
+	return tx.Where("policy = ? AND resource IN ?", policyID, entityIDs).Delete(&models.PolicyResourceRel{}).Error
+}
+
 // GetRelationType returns the relation type identifier
 func (r *ResourceRelationStrategy) GetRelationType() string {
 	return itemTypeResource
 }
 
+// insertIgnoringDuplicates inserts the slice in batches of batchSize and skips rows
+// whose primary key already exists, using ON CONFLICT DO NOTHING on the primary key
+// on PostgreSQL and SQLite and ON DUPLICATE KEY UPDATE on MySQL. Any other constraint
+// violation fails the insert. ON DUPLICATE KEY also matches the unique indexes, so a
+// batch is checked to have inserted every row whose key was new.
+func insertIgnoringDuplicates(tx *gorm.DB, rows interface{}, batchSize int) error {
+	value := reflect.ValueOf(rows)
+	if value.Len() == 0 {
+		return nil
+	}
+	if batchSize <= 0 {
+		batchSize = DefaultConfig().MaxBatchSize
+	}
+
+	stmt := &gorm.Statement{DB: tx}
+	if err := stmt.Parse(rows); err != nil {
+		return errors.WithStack(err)
+	}
+	columns := make([]clause.Column, len(stmt.Schema.PrimaryFieldDBNames))
+	for i, name := range stmt.Schema.PrimaryFieldDBNames {
+		columns[i] = clause.Column{Name: name}
+	}
+	conflict := clause.OnConflict{Columns: columns, DoNothing: true}
+	if tx.Dialector.Name() == "mysql" {
+		conflict = clause.OnConflict{DoUpdates: []clause.Assignment{{Column: columns[0], Value: columns[0]}}}
+	}
+
+	for start := 0; start < value.Len(); start += batchSize {
+		batch := value.Slice(start, min(start+batchSize, value.Len()))
+		keys := primaryKeys(tx, stmt.Schema, batch)
+		stored, err := countStoredKeys(tx, stmt.Schema, keys, false)
+		if err != nil {
+			return err
+		}
+
+		result := tx.Clauses(conflict).Omit(clause.Associations).Create(batch.Interface())
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == int64(len(keys))-stored {
+			continue
+		}
+
+		// A concurrent transaction may have inserted some of the keys in the meantime
+		if stored, err = countStoredKeys(tx, stmt.Schema, keys, true); err != nil {
+			return err
+		}
+		if missing := int64(len(keys)) - stored; missing > 0 {
+			return errors.Errorf("%d rows of %s were not inserted because they conflict with a unique index", missing, stmt.Schema.Table)
+		}
+	}
+	return nil
+}
+
+// primaryKeys returns the distinct primary keys of the rows in batch
+func primaryKeys(tx *gorm.DB, s *schema.Schema, batch reflect.Value) [][]interface{} {
+	keys := make([][]interface{}, 0, batch.Len())
+	seen := make(map[string]bool, batch.Len())
+	for i := 0; i < batch.Len(); i++ {
+		row := reflect.Indirect(batch.Index(i))
+		key := make([]interface{}, len(s.PrimaryFields))
+		for j, field := range s.PrimaryFields {
+			key[j], _ = field.ValueOf(tx.Statement.Context, row)
+		}
+		if id := fmt.Sprintf("%q", key); !seen[id] {
+			seen[id] = true
+			keys = append(keys, key)
+		}
+	}
+	return keys
+}
+
+// countStoredKeys counts the given primary keys stored in the table of s, including
+// soft deleted rows. A locking read sees rows committed by concurrent transactions.
+func countStoredKeys(tx *gorm.DB, s *schema.Schema, keys [][]interface{}, locking bool) (int64, error) {
+	var in clause.IN
+	if len(s.PrimaryFieldDBNames) == 1 {
+		in.Column = clause.Column{Name: s.PrimaryFieldDBNames[0]}
+		for _, key := range keys {
+			in.Values = append(in.Values, key[0])
+		}
+	} else {
+		columns := make([]clause.Column, len(s.PrimaryFieldDBNames))
+		for i, name := range s.PrimaryFieldDBNames {
+			columns[i] = clause.Column{Name: name}
+		}
+		in.Column = columns
+		for _, key := range keys {
+			in.Values = append(in.Values, key)
+		}
+	}
+
+	query := tx.Table(s.Table).Where(in)
+	if !locking {
+		var count int64
+		return count, errors.WithStack(query.Count(&count).Error)
+	}
+
+	// PostgreSQL does not lock rows of aggregates, so fetch the keys instead
+	var stored []map[string]interface{}
+	err := query.Select(s.PrimaryFieldDBNames).Clauses(clause.Locking{Strength: "SHARE"}).Find(&stored).Error
+	return int64(len(stored)), errors.WithStack(err)
+}
+
 // RelationStrategyRegistry manages the available relation strategies
 type RelationStrategyRegistry struct {
 	strategies map[string]RelationStrategy
@@ -154,6 +331,11 @@ func (c *RelationContext) PersistRelation(relation interface{}, tx *gorm.DB) err
 	return c.strategy.PersistRelation(relation, tx)
 }
 
+// DeleteRelations deletes relations using the current strategy
+func (c *RelationContext) DeleteRelations(policyID string, entityIDs []string, tx *gorm.DB) error {
+	return c.strategy.DeleteRelations(policyID, entityIDs, tx)
+}
+
 // RelationTypeDetector provides methods to detect relation types
 type RelationTypeDetector struct {
 	strategyRegistry *RelationStrategyRegistry
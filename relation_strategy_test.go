@@ -75,6 +75,22 @@ func TestResourceRelationStrategy(t *testing.T) {
 	}
 }
 
+func TestRelationStrategyPersistRelationsInvalidType(t *testing.T) {
+	strategies := []RelationStrategy{
+		&SubjectRelationStrategy{},
+		&ActionRelationStrategy{},
+		&ResourceRelationStrategy{},
+	}
+
+	// A relation of the wrong type is rejected before touching the database
+	invalid := []interface{}{"invalid-relation"}
+	for _, strategy := range strategies {
+		if err := strategy.PersistRelations(invalid, 10, nil); err != ErrInvalidRelationType {
+			t.Errorf("Expected ErrInvalidRelationType from %T, got %v", strategy, err)
+		}
+	}
+}
+
 func TestRelationStrategyRegistry(t *testing.T) {
 	registry := NewRelationStrategyRegistry()
 
@@ -0,0 +1,352 @@
+package ladonsqlmanager
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/ladonsqlmanager/models"
+	"github.com/ory/ladon"
+	"github.com/pkg/errors"
+	"gorm.io/gorm"
+)
+
+// SyncAction is a change Sync makes to a policy
+type SyncAction string
+
+const (
+	// SyncCreate creates a policy missing from the database
+	SyncCreate SyncAction = "create"
+	// SyncUpdate overwrites a stored policy that differs from the desired one
+	SyncUpdate SyncAction = "update"
+	// SyncDelete deletes a stored policy missing from the desired state
+	SyncDelete SyncAction = "delete"
+)
+
+// SyncChange is a planned change to a single policy
+type SyncChange struct {
+	ID     string
+	Action SyncAction
+	// Fields lists the attributes changed by an update
+	Fields []string
+	// Policy is the desired policy, nil for deletions
+	Policy ladon.Policy
+}
+
+// SyncPlan lists the changes that make the stored policies match the desired
+// ones, ordered by policy ID
+type SyncPlan struct {
+	Changes []SyncChange
+	// Unmanaged lists the stored policies missing from the desired state that are
+	// kept because pruning is off
+	Unmanaged []string
+}
+
+// HasChanges reports whether the stored policies differ from the desired ones
+func (p *SyncPlan) HasChanges() bool {
+	return len(p.Changes) > 0
+}
+
+// Count returns the number of planned changes with the given action
+func (p *SyncPlan) Count(action SyncAction) int {
+	count := 0
+	for _, change := range p.Changes {
+		if change.Action == action {
+			count++
+		}
+	}
+	return count
+}
+
+// syncSymbols prefix the changes printed by SyncPlan.Write
+var syncSymbols = map[SyncAction]string{
+	SyncCreate: "+",
+	SyncUpdate: "~",
+	SyncDelete: "-",
+}
+
+// Write prints the plan in a human readable form, one line per change followed by
+// a summary
+func (p *SyncPlan) Write(w io.Writer) error {
+	var b strings.Builder
+	if !p.HasChanges() {
+		b.WriteString("No changes. The stored policies match the desired state.\n")
+	}
+	for _, change := range p.Changes {
+		fmt.Fprintf(&b, "  %s %s", syncSymbols[change.Action], change.ID)
+		if len(change.Fields) > 0 {
+			fmt.Fprintf(&b, " (%s)", strings.Join(change.Fields, ", "))
+		}
+		b.WriteString("\n")
+	}
+	if p.HasChanges() {
+		fmt.Fprintf(&b, "\nPlan: %d to create, %d to update, %d to delete.\n",
+			p.Count(SyncCreate), p.Count(SyncUpdate), p.Count(SyncDelete))
+	}
+	if len(p.Unmanaged) > 0 {
+		fmt.Fprintf(&b, "%d stored policies missing from the desired state are kept, prune to delete them.\n", len(p.Unmanaged))
+	}
+	_, err := io.WriteString(w, b.String())
+	return errors.WithStack(err)
+}
+
+// LoadPolicies reads the policies of all JSON and YAML files in dir and its
+// subdirectories. A policy ID may only be used once across all files.
+func (s *SQLManager) LoadPolicies(dir string) (ladon.Policies, error) {
+	var policies ladon.Policies
+	sources := make(map[string]string)
+
+	err := filepath.WalkDir(dir, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		format, ok := FormatFromExtension(path)
+		if entry.IsDir() || !ok {
+			return nil
+		}
+
+		file, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+
+		decoded, err := s.DecodePolicies(file, format)
+		if err != nil {
+			return errors.Wrapf(err, "failed to read %s", path)
+		}
+		for _, policy := range decoded {
+			if other, ok := sources[policy.GetID()]; ok {
+				return errors.Errorf("policy %s is defined in %s and %s", policy.GetID(), other, path)
+			}
+			sources[policy.GetID()] = path
+		}
+		policies = append(policies, decoded...)
+		return nil
+	})
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	return policies, nil
+}
+
+// PlanSync compares the stored policies with the desired ones, in the form the
+// desired ones would be stored. Stored policies missing from desired are deleted
+// when prune is set, and listed as unmanaged otherwise. A desired policy failing
+// validation fails the plan.
+func (s *SQLManager) PlanSync(ctx context.Context, desired ladon.Policies, prune bool) (_ *SyncPlan, err error) {
+	ctx, op := s.startOperation(ctx, "PlanSync")
+	defer func() { op.finish(err) }()
+
+	stored, err := s.findPolicyModels(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	current, err := s.convertPoliciesToLadon(stored)
+	if err != nil {
+		return nil, err
+	}
+	op.metrics.Rows = len(current)
+
+	currentByID := make(map[string]ladon.Policy, len(current))
+	for _, policy := range current {
+		currentByID[policy.GetID()] = policy
+	}
+
+	plan := &SyncPlan{}
+	wanted := make(map[string]bool, len(desired))
+	for _, policy := range desired {
+		if wanted[policy.GetID()] {
+			return nil, errors.Errorf("policy %s is listed twice", policy.GetID())
+		}
+		wanted[policy.GetID()] = true
+
+		// Compare the policy as it would be stored, not as it was written
+		normalized, err := s.storedForm(policy)
+		if err != nil {
+			return nil, err
+		}
+		existing, ok := currentByID[policy.GetID()]
+		if !ok {
+			plan.Changes = append(plan.Changes, SyncChange{ID: policy.GetID(), Action: SyncCreate, Policy: policy})
+			continue
+		}
+		fields, err := changedFields(existing, normalized)
+		if err != nil {
+			return nil, err
+		}
+		if len(fields) > 0 {
+			plan.Changes = append(plan.Changes, SyncChange{ID: policy.GetID(), Action: SyncUpdate, Fields: fields, Policy: policy})
+		}
+	}
+
+	for _, policy := range current {
+		if wanted[policy.GetID()] {
+			continue
+		}
+		if prune {
+			plan.Changes = append(plan.Changes, SyncChange{ID: policy.GetID(), Action: SyncDelete})
+		} else {
+			plan.Unmanaged = append(plan.Unmanaged, policy.GetID())
+		}
+	}
+
+	sort.SliceStable(plan.Changes, func(i, j int) bool {
+		return plan.Changes[i].ID < plan.Changes[j].ID
+	})
+	sort.Strings(plan.Unmanaged)
+	return plan, nil
+}
+
+// storedForm returns the policy as it reads back once stored: templates trimmed,
+// listed once and without the ones skipped in lenient mode
+func (s *SQLManager) storedForm(policy ladon.Policy) (ladon.Policy, error) {
+	prepared, err := s.preparePolicy(policy)
+	if err != nil {
+		return nil, err
+	}
+	model := *prepared.model
+	for _, items := range prepared.items {
+		for _, entity := range items.entities {
+			switch items.itemType {
+			case itemTypeSubject:
+				model.Subjects = append(model.Subjects, models.Subject{BaseEntity: entity})
+			case itemTypeAction:
+				model.Actions = append(model.Actions, models.Action{BaseEntity: entity})
+			case itemTypeResource:
+				model.Resources = append(model.Resources, models.Resource{BaseEntity: entity})
+			}
+		}
+	}
+	return s.convertPolicyToLadon(model)
+}
+
+// changedFields lists the attributes in which the desired policy differs from the
+// stored one, comparing their exported forms
+func changedFields(stored, desired ladon.Policy) ([]string, error) {
+	a, err := newPolicyDocument(stored)
+	if err != nil {
+		return nil, err
+	}
+	b, err := newPolicyDocument(desired)
+	if err != nil {
+		return nil, err
+	}
+
+	// Missing and empty lists are the same
+	sameList := func(x, y []string) bool {
+		return (len(x) == 0 && len(y) == 0) || reflect.DeepEqual(x, y)
+	}
+
+	var fields []string
+	if a.Description != b.Description {
+		fields = append(fields, "description")
+	}
+	if a.Effect != b.Effect {
+		fields = append(fields, "effect")
+	}
+	if !sameList(a.Subjects, b.Subjects) {
+		fields = append(fields, "subjects")
+	}
+	if !sameList(a.Resources, b.Resources) {
+		fields = append(fields, "resources")
+	}
+	if !sameList(a.Actions, b.Actions) {
+		fields = append(fields, "actions")
+	}
+	if !reflect.DeepEqual(a.Conditions, b.Conditions) {
+		fields = append(fields, "conditions")
+	}
+	if !reflect.DeepEqual(a.Meta, b.Meta) {
+		fields = append(fields, "meta")
+	}
+	if a.StartDelimiter != b.StartDelimiter || a.EndDelimiter != b.EndDelimiter {
+		fields = append(fields, "delimiters")
+	}
+	return fields, nil
+}
+
+// ApplySync applies the plan in a single transaction, so either all or none of its
+// changes are stored. Config.QueryTimeout applies to the whole transaction.
+func (s *SQLManager) ApplySync(ctx context.Context, plan *SyncPlan) (err error) {
+	ctx, op := s.startOperation(ctx, "ApplySync")
+	defer func() { op.finish(err) }()
+
+	// Validation failures abort the plan without touching the database
+	prepared := make([]*preparedPolicy, len(plan.Changes))
+	items := make([]policyItems, 0, 3*len(plan.Changes))
+	for i, change := range plan.Changes {
+		if change.Action == SyncDelete {
+			continue
+		}
+		if prepared[i], err = s.preparePolicy(change.Policy); err != nil {
+			return err
+		}
+		items = append(items, prepared[i].items...)
+	}
+
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	err = s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := s.insertEntities(items, tx); err != nil {
+			return err
+		}
+
+		for i, change := range plan.Changes {
+			switch change.Action {
+			case SyncCreate:
+				// Replaces a soft-deleted policy with the same ID
+				err := s.update(prepared[i], tx)
+				if errors.Is(err, ErrPolicyNotFound) {
+					err = s.insertPolicy(prepared[i], tx)
+				}
+				if err != nil {
+					return err
+				}
+			case SyncUpdate:
+				if err := s.update(prepared[i], tx); err != nil {
+					return err
+				}
+			case SyncDelete:
+				deleted, err := s.delete(change.ID, tx)
+				if err != nil {
+					return err
+				}
+				if deleted == 0 {
+					return errors.WithStack(ErrPolicyNotFound)
+				}
+			default:
+				return errors.Errorf("unknown sync action %q", change.Action)
+			}
+			if err := s.publishChange(tx, change.ID); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return timeoutError(err)
+	}
+	op.metrics.Rows = len(plan.Changes)
+	return nil
+}
+
+// Sync makes the stored policies match the desired ones with PlanSync and
+// ApplySync, and returns the applied plan
+func (s *SQLManager) Sync(ctx context.Context, desired ladon.Policies, prune bool) (*SyncPlan, error) {
+	plan, err := s.PlanSync(ctx, desired, prune)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.ApplySync(ctx, plan); err != nil {
+		return nil, err
+	}
+	return plan, nil
+}
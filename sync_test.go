@@ -0,0 +1,164 @@
+package ladonsqlmanager
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/ory/ladon"
+	"github.com/pkg/errors"
+)
+
+// writePolicyFile writes content to name within dir
+func writePolicyFile(t *testing.T, dir, name, content string) {
+	t.Helper()
+	path := filepath.Join(dir, name)
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		t.Fatalf("Failed to create directory: %v", err)
+	}
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatalf("Failed to write %s: %v", name, err)
+	}
+}
+
+func TestSQLiteSync(t *testing.T) {
+	ctx := context.Background()
+	manager := newTestManager(t)
+	createTestPolicies(t, manager, exportTestPolicies...)
+	createTestPolicies(t, manager, &ladon.DefaultPolicy{
+		ID:          "3",
+		Description: "not in the files",
+		Subjects:    []string{"guest"},
+		Effect:      ladon.AllowAccess,
+		Resources:   []string{"articles"},
+		Actions:     []string{"list"},
+	})
+
+	// Policy 2 unchanged, policy 1 with another description, policy 4 added
+	dir := t.TempDir()
+	var exported strings.Builder
+	if err := EncodePolicies(&exported, FormatJSON, exportTestPolicies[1:]); err != nil {
+		t.Fatalf("Failed to encode policies: %v", err)
+	}
+	writePolicyFile(t, dir, "drafts.json", exported.String())
+	writePolicyFile(t, dir, "articles/editors.yaml", `
+- id: "1"
+  description: editors can edit articles
+  subjects: [editor, "users:<[0-9]+>"]
+  effect: allow
+  resources: ["articles:<.*>"]
+  actions: [write, read]
+  conditions:
+    department:
+      type: StringEqualCondition
+      options:
+        equals: editorial
+  meta:
+    owner: editorial
+    tags: [cms]
+- id: "4"
+  description: admins can do anything
+  subjects: [admin]
+  effect: allow
+  resources: ["<.*>"]
+  actions: ["<.*>"]
+`)
+	writePolicyFile(t, dir, "README.md", "ignored")
+
+	desired, err := manager.LoadPolicies(dir)
+	if err != nil {
+		t.Fatalf("Failed to load policies: %v", err)
+	}
+	if len(desired) != 3 {
+		t.Fatalf("Expected 3 desired policies, got %d", len(desired))
+	}
+
+	plan, err := manager.PlanSync(ctx, desired, false)
+	if err != nil {
+		t.Fatalf("Failed to plan: %v", err)
+	}
+	var printed strings.Builder
+	if err := plan.Write(&printed); err != nil {
+		t.Fatalf("Failed to print plan: %v", err)
+	}
+	expected := "  ~ 1 (description)\n  + 4\n\nPlan: 1 to create, 1 to update, 0 to delete.\n" +
+		"1 stored policies missing from the desired state are kept, prune to delete them.\n"
+	if printed.String() != expected {
+		t.Errorf("Expected plan\n%s\ngot\n%s", expected, printed.String())
+	}
+
+	if err := manager.ApplySync(ctx, plan); err != nil {
+		t.Fatalf("Failed to apply plan: %v", err)
+	}
+	if plan, err := manager.PlanSync(ctx, desired, false); err != nil || plan.HasChanges() {
+		t.Errorf("Expected no drift after applying, got %+v, %v", plan, err)
+	}
+
+	plan, err = manager.Sync(ctx, desired, true)
+	if err != nil {
+		t.Fatalf("Failed to sync with pruning: %v", err)
+	}
+	if len(plan.Changes) != 1 || plan.Changes[0].ID != "3" || plan.Changes[0].Action != SyncDelete {
+		t.Errorf("Expected policy 3 to be pruned, got %+v", plan.Changes)
+	}
+	if _, err := manager.Get(ctx, "3"); !errors.Is(err, ErrPolicyNotFound) {
+		t.Errorf("Expected the pruned policy to be deleted, got %v", err)
+	}
+
+	writePolicyFile(t, dir, "copy.yml", `[{id: "4", description: duplicate, effect: deny}]`)
+	if _, err := manager.LoadPolicies(dir); err == nil {
+		t.Error("Expected an error for a policy defined in two files")
+	}
+}
+
+func TestSQLiteApplySyncIsAtomic(t *testing.T) {
+	ctx := context.Background()
+	manager := newTestManager(t)
+
+	plan := &SyncPlan{Changes: []SyncChange{
+		{ID: "1", Action: SyncCreate, Policy: exportTestPolicies[0]},
+		{ID: "missing", Action: SyncDelete},
+	}}
+	if err := manager.ApplySync(ctx, plan); !errors.Is(err, ErrPolicyNotFound) {
+		t.Fatalf("Expected ErrPolicyNotFound for a stale plan, got %v", err)
+	}
+	if _, err := manager.Get(ctx, "1"); !errors.Is(err, ErrPolicyNotFound) {
+		t.Errorf("Expected a failed plan to store nothing, got %v", err)
+	}
+}
+
+func TestSQLiteSyncNormalizesDesiredPolicies(t *testing.T) {
+	ctx := context.Background()
+	config := DefaultConfig()
+	config.LenientTemplates = true
+	manager := newTestManagerWithConfig(t, config)
+
+	// Stored trimmed and without the invalid template
+	desired := ladon.Policies{&ladon.DefaultPolicy{
+		ID:          "1",
+		Description: "editors can edit articles",
+		Subjects:    []string{" editor ", "users:<[0-9]+"},
+		Effect:      ladon.AllowAccess,
+		Resources:   []string{"articles:<.*>"},
+		Actions:     []string{"write", "read"},
+	}}
+	plan, err := manager.Sync(ctx, desired, false)
+	if err != nil {
+		t.Fatalf("Failed to sync: %v", err)
+	}
+	if len(plan.Changes) != 1 || plan.Changes[0].Action != SyncCreate {
+		t.Errorf("Expected policy 1 to be created, got %+v", plan.Changes)
+	}
+
+	if plan, err := manager.PlanSync(ctx, desired, false); err != nil || plan.HasChanges() {
+		t.Errorf("Expected no drift after applying, got %+v, %v", plan, err)
+	}
+
+	// Validation failures fail the plan rather than its application
+	desired[0].(*ladon.DefaultPolicy).Effect = "maybe"
+	if _, err := manager.PlanSync(ctx, desired, false); err == nil {
+		t.Error("Expected an invalid desired policy to fail the plan")
+	}
+}
@@ -0,0 +1,90 @@
+package ladonsqlmanager
+
+import (
+	"regexp"
+	"sync"
+
+	"github.com/ladonsqlmanager/models"
+	"github.com/pkg/errors"
+)
+
+// templateMatcher matches values against stored entities in Go. It is used for
+// drivers without a regex operator, such as SQLite, and caches compiled patterns
+// keyed by the compiled column so each pattern is only parsed once.
+type templateMatcher struct {
+	patterns sync.Map
+}
+
+// newTemplateMatcher creates a new, empty templateMatcher
+func newTemplateMatcher() *templateMatcher {
+	return &templateMatcher{}
+}
+
+// Matches reports whether value matches the entity. Regex entities are matched
+// against their compiled pattern, all others by exact template comparison.
+func (m *templateMatcher) Matches(entity models.BaseEntity, value string) (bool, error) {
+	if !entity.HasRegex {
+		return entity.Template == value, nil
+	}
+
+	pattern, err := m.pattern(entity.Compiled)
+	if err != nil {
+		return false, err
+	}
+	return pattern.MatchString(value), nil
+}
+
+// MatchesAny reports whether value matches at least one of the entities
+func (m *templateMatcher) MatchesAny(entities []models.BaseEntity, value string) (bool, error) {
+	for _, entity := range entities {
+		matched, err := m.Matches(entity, value)
+		if err != nil {
+			return false, err
+		}
+		if matched {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// pattern returns the cached regular expression for compiled, compiling it on first use
+func (m *templateMatcher) pattern(compiled string) (*regexp.Regexp, error) {
+	if cached, ok := m.patterns.Load(compiled); ok {
+		return cached.(*regexp.Regexp), nil
+	}
+
+	pattern, err := regexp.Compile(compiled)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	m.patterns.Store(compiled, pattern)
+	return pattern, nil
+}
+
+// subjectEntities returns the base entities of the policy's subjects
+func subjectEntities(policy models.Policy) []models.BaseEntity {
+	entities := make([]models.BaseEntity, len(policy.Subjects))
+	for i, subject := range policy.Subjects {
+		entities[i] = subject.BaseEntity
+	}
+	return entities
+}
+
+// actionEntities returns the base entities of the policy's actions
+func actionEntities(policy models.Policy) []models.BaseEntity {
+	entities := make([]models.BaseEntity, len(policy.Actions))
+	for i, action := range policy.Actions {
+		entities[i] = action.BaseEntity
+	}
+	return entities
+}
+
+// resourceEntities returns the base entities of the policy's resources
+func resourceEntities(policy models.Policy) []models.BaseEntity {
+	entities := make([]models.BaseEntity, len(policy.Resources))
+	for i, resource := range policy.Resources {
+		entities[i] = resource.BaseEntity
+	}
+	return entities
+}
@@ -0,0 +1,126 @@
+package ladonsqlmanager
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/pkg/errors"
+	"gorm.io/gorm"
+)
+
+// withTimeout bounds ctx by Config.QueryTimeout, unless the timeout is disabled or
+// ctx already has an earlier deadline
+func (s *SQLManager) withTimeout(ctx context.Context) (context.Context, context.CancelFunc) {
+	if s.config.QueryTimeout <= 0 {
+		return ctx, func() {}
+	}
+	if deadline, ok := ctx.Deadline(); ok && time.Until(deadline) <= s.config.QueryTimeout {
+		return ctx, func() {}
+	}
+	return context.WithTimeout(ctx, s.config.QueryTimeout)
+}
+
+// statementTimeoutKey marks contexts whose statements are each bounded by the
+// duration it holds
+type statementTimeoutKey struct{}
+
+// statementDeadlineKey is the statement instance key of the deadline set by the GORM
+// callbacks
+const statementDeadlineKey = "ladonsqlmanager:statement_deadline"
+
+// statementDeadline is the context a statement ran with before its deadline was set
+type statementDeadline struct {
+	parent context.Context
+	cancel context.CancelFunc
+}
+
+// withStatementTimeout bounds each statement run with ctx by Config.QueryTimeout,
+// for calls that run too many statements to be bounded as a whole
+func (s *SQLManager) withStatementTimeout(ctx context.Context) context.Context {
+	if s.config.QueryTimeout <= 0 {
+		return ctx
+	}
+	return context.WithValue(ctx, statementTimeoutKey{}, s.config.QueryTimeout)
+}
+
+// registerTimeoutCallbacks sets the deadline of statements made with a context of
+// withStatementTimeout: queries, creates, updates, deletes and raw statements. Row
+// and Rows results are read after the callbacks, so they are not bounded. The
+// callbacks are registered once per gorm.DB.
+func registerTimeoutCallbacks(db *gorm.DB) error {
+	callbacks := db.Callback()
+	if callbacks.Query().Get("ladonsqlmanager:start_query_deadline") != nil {
+		return nil
+	}
+
+	chains := []struct {
+		name       string
+		start, end func(name string, fn func(*gorm.DB)) error
+	}{
+		{"query", callbacks.Query().Before("gorm:query").Register, callbacks.Query().After("gorm:after_query").Register},
+		{"create", callbacks.Create().Before("gorm:create").Register, callbacks.Create().After("gorm:after_create").Register},
+		{"update", callbacks.Update().Before("gorm:update").Register, callbacks.Update().After("gorm:after_update").Register},
+		{"delete", callbacks.Delete().Before("gorm:delete").Register, callbacks.Delete().After("gorm:after_delete").Register},
+		{"raw", callbacks.Raw().Before("gorm:raw").Register, callbacks.Raw().After("gorm:raw").Register},
+	}
+	for _, chain := range chains {
+		if err := chain.start("ladonsqlmanager:start_"+chain.name+"_deadline", startStatementDeadline); err != nil {
+			return err
+		}
+		if err := chain.end("ladonsqlmanager:end_"+chain.name+"_deadline", endStatementDeadline); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func startStatementDeadline(db *gorm.DB) {
+	parent := db.Statement.Context
+	if parent == nil {
+		return
+	}
+	timeout, ok := parent.Value(statementTimeoutKey{}).(time.Duration)
+	if !ok {
+		return
+	}
+	ctx, cancel := context.WithTimeout(parent, timeout)
+	db.Statement.Context = ctx
+	db.InstanceSet(statementDeadlineKey, statementDeadline{parent: parent, cancel: cancel})
+}
+
+func endStatementDeadline(db *gorm.DB) {
+	value, ok := db.InstanceGet(statementDeadlineKey)
+	if !ok {
+		return
+	}
+	deadline := value.(statementDeadline)
+	deadline.cancel()
+	db.Statement.Context = deadline.parent
+}
+
+// timeoutError wraps err with ErrQueryTimeout when it reports a statement that ran
+// past its deadline: context.DeadlineExceeded, or the driver error of a cancelled
+// statement, SQLSTATE 57014 on PostgreSQL and error 1317 on MySQL. Other errors are
+// returned unchanged, even when the deadline expired since.
+func timeoutError(err error) error {
+	if err == nil {
+		return nil
+	}
+
+	timedOut := errors.Is(err, context.DeadlineExceeded)
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) {
+		timedOut = timedOut || pgErr.Code == "57014"
+	}
+	var mysqlErr *mysql.MySQLError
+	if errors.As(err, &mysqlErr) {
+		timedOut = timedOut || mysqlErr.Number == 1317
+	}
+	if !timedOut {
+		return err
+	}
+	return errors.WithStack(fmt.Errorf("%w: %w", ErrQueryTimeout, err))
+}
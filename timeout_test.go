@@ -0,0 +1,184 @@
+package ladonsqlmanager
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/ladonsqlmanager/migrations"
+	"github.com/ory/ladon"
+	"github.com/pkg/errors"
+	"gorm.io/gorm"
+)
+
+func TestWithTimeout(t *testing.T) {
+	config := DefaultConfig()
+	config.QueryTimeout = time.Minute
+	manager := New(nil, "postgres")
+	manager.config = config
+
+	// A context without deadline gets the configured timeout
+	ctx, cancel := manager.withTimeout(context.Background())
+	deadline, ok := ctx.Deadline()
+	cancel()
+	if !ok {
+		t.Fatal("Expected a deadline")
+	}
+	if remaining := time.Until(deadline); remaining > time.Minute || remaining < 59*time.Second {
+		t.Errorf("Expected deadline about a minute away, got %v", remaining)
+	}
+
+	// An earlier deadline of the caller is kept
+	parent, parentCancel := context.WithTimeout(context.Background(), time.Second)
+	defer parentCancel()
+	ctx, cancel = manager.withTimeout(parent)
+	cancel()
+	if ctx != parent {
+		t.Error("Expected the caller's earlier deadline to be kept")
+	}
+
+	// A later deadline of the caller is shortened
+	parent, parentCancel = context.WithTimeout(context.Background(), time.Hour)
+	defer parentCancel()
+	ctx, cancel = manager.withTimeout(parent)
+	deadline, _ = ctx.Deadline()
+	cancel()
+	if time.Until(deadline) > time.Minute {
+		t.Errorf("Expected deadline within a minute, got %v", time.Until(deadline))
+	}
+
+	// A zero timeout disables the bound
+	manager.config.QueryTimeout = 0
+	ctx, cancel = manager.withTimeout(context.Background())
+	cancel()
+	if _, ok := ctx.Deadline(); ok {
+		t.Error("Expected no deadline when QueryTimeout is zero")
+	}
+}
+
+func TestTimeoutError(t *testing.T) {
+	if err := timeoutError(nil); err != nil {
+		t.Errorf("Expected nil, got %v", err)
+	}
+
+	other := errors.New("boom")
+	if err := timeoutError(other); err != other {
+		t.Errorf("Expected unrelated error to be kept, got %v", err)
+	}
+
+	deadline := errors.WithStack(context.DeadlineExceeded)
+	if err := timeoutError(deadline); !errors.Is(err, ErrQueryTimeout) || !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Expected ErrQueryTimeout wrapping the deadline error, got %v", err)
+	}
+
+	// Statements cancelled by the driver
+	for _, cancelled := range []error{
+		&pgconn.PgError{Code: "57014", Message: "canceling statement due to statement timeout"},
+		&mysql.MySQLError{Number: 1317, Message: "Query execution was interrupted"},
+	} {
+		err := timeoutError(errors.WithStack(cancelled))
+		if !errors.Is(err, ErrQueryTimeout) || !errors.Is(err, cancelled) {
+			t.Errorf("Expected ErrQueryTimeout wrapping %v, got %v", cancelled, err)
+		}
+	}
+
+	if err := timeoutError(context.Canceled); errors.Is(err, ErrQueryTimeout) {
+		t.Errorf("Expected cancellation not to be reported as timeout, got %v", err)
+	}
+
+	// Other errors are kept even after the deadline expired
+	if err := timeoutError(ErrTemplateConflict); err != ErrTemplateConflict {
+		t.Errorf("Expected ErrTemplateConflict to be kept, got %v", err)
+	}
+	duplicate := &pgconn.PgError{Code: "23505"}
+	if err := timeoutError(duplicate); err != duplicate {
+		t.Errorf("Expected the unique violation to be kept, got %v", err)
+	}
+}
+
+func TestSQLiteQueryTimeout(t *testing.T) {
+	manager := newTestManager(t)
+	createTestPolicies(t, manager, &ladon.DefaultPolicy{
+		ID:          "1",
+		Description: "users can read articles",
+		Subjects:    []string{"user"},
+		Effect:      ladon.AllowAccess,
+		Resources:   []string{"article:1"},
+		Actions:     []string{"read"},
+	})
+
+	ctx, cancel := context.WithDeadline(context.Background(), time.Now().Add(-time.Second))
+	defer cancel()
+
+	if _, err := manager.Get(ctx, "1"); !errors.Is(err, ErrQueryTimeout) {
+		t.Errorf("Expected ErrQueryTimeout from Get, got %v", err)
+	}
+	if _, err := manager.FindPoliciesForSubject(ctx, "user"); !errors.Is(err, ErrQueryTimeout) {
+		t.Errorf("Expected ErrQueryTimeout from FindPoliciesForSubject, got %v", err)
+	}
+	if err := manager.Delete(ctx, "1"); !errors.Is(err, ErrQueryTimeout) {
+		t.Errorf("Expected ErrQueryTimeout from Delete, got %v", err)
+	}
+
+	// The policy was not touched
+	if _, err := manager.Get(context.Background(), "1"); err != nil {
+		t.Errorf("Expected policy to survive the timed out delete, got %v", err)
+	}
+}
+
+func TestSQLiteImportQueryTimeout(t *testing.T) {
+	expired, cancel := context.WithDeadline(context.Background(), time.Now().Add(-time.Second))
+	defer cancel()
+
+	manager := newTestManager(t)
+	_, err := manager.Import(expired, strings.NewReader(`[]`), FormatJSON, ImportUpsert)
+	if !errors.Is(err, ErrQueryTimeout) {
+		t.Errorf("Expected ErrQueryTimeout from Import, got %v", err)
+	}
+
+	legacy := New(newLegacyTestDB(t), "sqlite")
+	if _, err := legacy.ImportLegacy(expired, nil); !errors.Is(err, ErrQueryTimeout) {
+		t.Errorf("Expected ErrQueryTimeout from ImportLegacy, got %v", err)
+	}
+	if _, err := legacy.PlanImportLegacy(expired, nil, &migrations.Plan{}); !errors.Is(err, ErrQueryTimeout) {
+		t.Errorf("Expected ErrQueryTimeout from PlanImportLegacy, got %v", err)
+	}
+	if isLegacy, err := migrations.IsLegacySchema(legacy.db); err != nil || !isLegacy {
+		t.Errorf("Expected the timed out import to keep the legacy schema, got %v, %v", isLegacy, err)
+	}
+}
+
+// slowTransport is a ChangeTransport taking its time to publish
+type slowTransport struct {
+	*InProcessTransport
+	delay time.Duration
+}
+
+func (t *slowTransport) Publish(tx *gorm.DB, policyID string) error {
+	time.Sleep(t.delay)
+	return t.InProcessTransport.Publish(tx, policyID)
+}
+
+func TestSQLiteImportLegacyStatementTimeout(t *testing.T) {
+	// The import takes longer than QueryTimeout, but none of its statements does
+	config := DefaultConfig()
+	config.QueryTimeout = 50 * time.Millisecond
+	config.ChangeTransport = &slowTransport{InProcessTransport: NewInProcessTransport(), delay: 30 * time.Millisecond}
+	manager := NewWithConfig(newLegacyTestDB(t), "sqlite", config)
+
+	report, err := manager.ImportLegacy(context.Background(), nil)
+	if err != nil {
+		t.Fatalf("ImportLegacy failed: %v", err)
+	}
+	checkLegacyImport(t, manager, report)
+
+	// Each statement is still bounded
+	config.QueryTimeout = time.Nanosecond
+	legacy := NewWithConfig(newLegacyTestDB(t), "sqlite", config)
+	if _, err := legacy.ImportLegacy(context.Background(), nil); !errors.Is(err, ErrQueryTimeout) {
+		t.Errorf("Expected ErrQueryTimeout from ImportLegacy, got %v", err)
+	}
+}
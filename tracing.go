@@ -0,0 +1,255 @@
+package ladonsqlmanager
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Span attribute keys set by SQLManager
+const (
+	AttributeDBSystem        = "db.system"
+	AttributeDBTable         = "db.sql.table"
+	AttributeDBRows          = "db.rows_affected"
+	AttributePolicyID        = "ladon.policy.id"
+	AttributeRequestSubject  = "ladon.request.subject"
+	AttributeRequestAction   = "ladon.request.action"
+	AttributeRequestResource = "ladon.request.resource"
+	AttributeRows            = "ladon.rows"
+	AttributeCandidates      = "ladon.candidates"
+)
+
+// Tracer starts spans. It mirrors the OpenTelemetry tracer API so an adapter
+// around go.opentelemetry.io/otel/trace is a few lines. Implementations must keep
+// track of the parent span through the returned context.
+type Tracer interface {
+	Start(ctx context.Context, name string) (context.Context, Span)
+}
+
+// Span is a single traced operation
+type Span interface {
+	SetAttribute(key string, value interface{})
+	RecordError(err error)
+	End()
+}
+
+// noopSpan is used when no Tracer is configured
+type noopSpan struct{}
+
+func (noopSpan) SetAttribute(string, interface{}) {}
+func (noopSpan) RecordError(error)                {}
+func (noopSpan) End()                             {}
+
+// tracerContextKey marks contexts of SQLManager calls for the GORM callbacks
+type tracerContextKey struct{}
+
+// querySpanKey is the statement instance key of the span opened by the GORM callbacks
+const querySpanKey = "ladonsqlmanager:query_span"
+
+// startSpan opens the span of a SQLManager call
+func (s *SQLManager) startSpan(ctx context.Context, name string) (context.Context, Span) {
+	if s.config.Tracer == nil {
+		return ctx, noopSpan{}
+	}
+	ctx, span := s.config.Tracer.Start(ctx, "ladonsqlmanager."+name)
+	span.SetAttribute(AttributeDBSystem, s.driverName)
+	return context.WithValue(ctx, tracerContextKey{}, s.config.Tracer), span
+}
+
+// registerTracingCallbacks opens a child span for every statement GORM runs within a
+// traced SQLManager call: queries including those of preloads, creates, updates,
+// deletes and raw statements. The callbacks are registered once per gorm.DB and
+// ignore statements made outside of SQLManager.
+func registerTracingCallbacks(db *gorm.DB) error {
+	callbacks := db.Callback()
+	if callbacks.Query().Get("ladonsqlmanager:start_query_span") != nil {
+		return nil
+	}
+
+	// Each chain registers the callbacks named start and end around its statement
+	chains := []struct {
+		name     string
+		register func(start, end string) error
+	}{
+		{"query", func(start, end string) error {
+			if err := callbacks.Query().Before("gorm:query").Register(start, startQuerySpan("gorm.query")); err != nil {
+				return err
+			}
+			return callbacks.Query().After("gorm:after_query").Register(end, endQuerySpan)
+		}},
+		{"create", func(start, end string) error {
+			if err := callbacks.Create().Before("gorm:create").Register(start, startQuerySpan("gorm.create")); err != nil {
+				return err
+			}
+			return callbacks.Create().After("gorm:after_create").Register(end, endQuerySpan)
+		}},
+		{"update", func(start, end string) error {
+			if err := callbacks.Update().Before("gorm:update").Register(start, startQuerySpan("gorm.update")); err != nil {
+				return err
+			}
+			return callbacks.Update().After("gorm:after_update").Register(end, endQuerySpan)
+		}},
+		{"delete", func(start, end string) error {
+			if err := callbacks.Delete().Before("gorm:delete").Register(start, startQuerySpan("gorm.delete")); err != nil {
+				return err
+			}
+			return callbacks.Delete().After("gorm:after_delete").Register(end, endQuerySpan)
+		}},
+		{"row", func(start, end string) error {
+			if err := callbacks.Row().Before("gorm:row").Register(start, startQuerySpan("gorm.row")); err != nil {
+				return err
+			}
+			return callbacks.Row().After("gorm:row").Register(end, endQuerySpan)
+		}},
+		{"raw", func(start, end string) error {
+			if err := callbacks.Raw().Before("gorm:raw").Register(start, startQuerySpan("gorm.raw")); err != nil {
+				return err
+			}
+			return callbacks.Raw().After("gorm:raw").Register(end, endQuerySpan)
+		}},
+	}
+	for _, chain := range chains {
+		if err := chain.register("ladonsqlmanager:start_"+chain.name+"_span", "ladonsqlmanager:end_"+chain.name+"_span"); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// startQuerySpan returns a callback opening a span with the given name
+func startQuerySpan(name string) func(*gorm.DB) {
+	return func(db *gorm.DB) {
+		ctx := db.Statement.Context
+		if ctx == nil {
+			return
+		}
+		tracer, ok := ctx.Value(tracerContextKey{}).(Tracer)
+		if !ok {
+			return
+		}
+		_, span := tracer.Start(ctx, name)
+		db.InstanceSet(querySpanKey, span)
+	}
+}
+
+func endQuerySpan(db *gorm.DB) {
+	value, ok := db.InstanceGet(querySpanKey)
+	if !ok {
+		return
+	}
+	span := value.(Span)
+	span.SetAttribute(AttributeDBTable, db.Statement.Table)
+	span.SetAttribute(AttributeDBRows, db.Statement.RowsAffected)
+	if db.Error != nil {
+		span.RecordError(db.Error)
+	}
+	span.End()
+}
+
+// RecordedSpan is a span captured by InMemoryTracer
+type RecordedSpan struct {
+	ID         uint64
+	ParentID   uint64
+	Name       string
+	Attributes map[string]interface{}
+	Err        error
+	Start      time.Time
+	End        time.Time
+}
+
+// InMemoryTracer is a Tracer that keeps finished spans in memory, meant for tests
+type InMemoryTracer struct {
+	mu     sync.Mutex
+	nextID uint64
+	spans  []RecordedSpan
+}
+
+// NewInMemoryTracer creates an empty InMemoryTracer
+func NewInMemoryTracer() *InMemoryTracer {
+	return &InMemoryTracer{}
+}
+
+// inMemorySpanKey holds the ID of the current in-memory span in a context
+type inMemorySpanKey struct{}
+
+// Start implements Tracer
+func (t *InMemoryTracer) Start(ctx context.Context, name string) (context.Context, Span) {
+	t.mu.Lock()
+	t.nextID++
+	id := t.nextID
+	t.mu.Unlock()
+
+	parentID, _ := ctx.Value(inMemorySpanKey{}).(uint64)
+	span := &inMemorySpan{
+		tracer: t,
+		span: RecordedSpan{
+			ID:         id,
+			ParentID:   parentID,
+			Name:       name,
+			Attributes: make(map[string]interface{}),
+			Start:      time.Now(),
+		},
+	}
+	return context.WithValue(ctx, inMemorySpanKey{}, id), span
+}
+
+// Spans returns the finished spans in the order they ended
+func (t *InMemoryTracer) Spans() []RecordedSpan {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	spans := make([]RecordedSpan, len(t.spans))
+	for i, span := range t.spans {
+		spans[i] = span
+		spans[i].Attributes = make(map[string]interface{}, len(span.Attributes))
+		for key, value := range span.Attributes {
+			spans[i].Attributes[key] = value
+		}
+	}
+	return spans
+}
+
+// Reset discards all finished spans
+func (t *InMemoryTracer) Reset() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.spans = nil
+}
+
+// inMemorySpan is the Span returned by InMemoryTracer
+type inMemorySpan struct {
+	tracer *InMemoryTracer
+	mu     sync.Mutex
+	span   RecordedSpan
+	ended  bool
+}
+
+func (s *inMemorySpan) SetAttribute(key string, value interface{}) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.span.Attributes[key] = value
+}
+
+func (s *inMemorySpan) RecordError(err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.span.Err = err
+}
+
+func (s *inMemorySpan) End() {
+	s.mu.Lock()
+	if s.ended {
+		s.mu.Unlock()
+		return
+	}
+	s.ended = true
+	s.span.End = time.Now()
+	span := s.span
+	s.mu.Unlock()
+
+	s.tracer.mu.Lock()
+	defer s.tracer.mu.Unlock()
+	s.tracer.spans = append(s.tracer.spans, span)
+}
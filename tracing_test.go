@@ -0,0 +1,174 @@
+package ladonsqlmanager
+
+import (
+	"context"
+	"testing"
+
+	"github.com/ladonsqlmanager/models"
+	"github.com/ory/ladon"
+)
+
+func TestInMemoryTracer(t *testing.T) {
+	tracer := NewInMemoryTracer()
+
+	ctx, parent := tracer.Start(context.Background(), "parent")
+	_, child := tracer.Start(ctx, "child")
+	child.SetAttribute("key", "value")
+	child.End()
+	child.End()
+	parent.End()
+
+	spans := tracer.Spans()
+	if len(spans) != 2 {
+		t.Fatalf("Expected 2 spans, got %d", len(spans))
+	}
+	if spans[0].Name != "child" || spans[1].Name != "parent" {
+		t.Errorf("Expected spans in the order they ended, got %s and %s", spans[0].Name, spans[1].Name)
+	}
+	if spans[0].ParentID != spans[1].ID || spans[1].ParentID != 0 {
+		t.Errorf("Expected child of %d, got parent %d", spans[1].ID, spans[0].ParentID)
+	}
+	if spans[0].Attributes["key"] != "value" {
+		t.Errorf("Expected attribute to be recorded, got %v", spans[0].Attributes)
+	}
+
+	tracer.Reset()
+	if len(tracer.Spans()) != 0 {
+		t.Error("Expected no spans after Reset")
+	}
+}
+
+func TestSQLiteTracing(t *testing.T) {
+	tracer := NewInMemoryTracer()
+	config := DefaultConfig()
+	config.Tracer = tracer
+	manager := newTestManagerWithConfig(t, config)
+	ctx := context.Background()
+
+	createTestPolicies(t, manager, &ladon.DefaultPolicy{
+		ID:          "1",
+		Description: "users can read articles",
+		Subjects:    []string{"user"},
+		Effect:      ladon.AllowAccess,
+		Resources:   []string{"article:1"},
+		Actions:     []string{"read"},
+	})
+	tracer.Reset()
+
+	if _, err := manager.Get(ctx, "1"); err != nil {
+		t.Fatalf("Get failed: %v", err)
+	}
+
+	spans := tracer.Spans()
+	get := spans[len(spans)-1]
+	if get.Name != "ladonsqlmanager.Get" {
+		t.Fatalf("Expected the Get span to end last, got %s", get.Name)
+	}
+	if get.Attributes[AttributePolicyID] != "1" || get.Attributes[AttributeDBSystem] != "sqlite" || get.Attributes[AttributeRows] != 1 {
+		t.Errorf("Expected policy ID, driver and row count attributes, got %v", get.Attributes)
+	}
+
+	// The policy query and the preloads of its subjects, actions and resources
+	tables := map[string]bool{}
+	for _, span := range spans[:len(spans)-1] {
+		if span.ParentID != get.ID {
+			t.Errorf("Expected span %s to be a child of Get", span.Name)
+		}
+		if table, ok := span.Attributes[AttributeDBTable].(string); ok {
+			tables[table] = true
+		}
+	}
+	for _, table := range []string{models.TableNamePolicy, models.TableNameSubject, models.TableNameAction, models.TableNameResource} {
+		if !tables[table] {
+			t.Errorf("Expected a query span for %s, got %v", table, tables)
+		}
+	}
+
+	tracer.Reset()
+	candidates, err := manager.FindRequestCandidates(ctx, &ladon.Request{Subject: "user", Action: "read", Resource: "article:1"})
+	if err != nil {
+		t.Fatalf("FindRequestCandidates failed: %v", err)
+	}
+	spans = tracer.Spans()
+	find := spans[len(spans)-1]
+	if find.Attributes[AttributeRequestSubject] != "user" || find.Attributes[AttributeCandidates] != len(candidates) {
+		t.Errorf("Expected subject and candidate count attributes, got %v", find.Attributes)
+	}
+
+	tracer.Reset()
+	if _, err := manager.Get(ctx, "missing"); err == nil {
+		t.Fatal("Expected Get of a missing policy to fail")
+	}
+	spans = tracer.Spans()
+	if spans[len(spans)-1].Err == nil {
+		t.Error("Expected the error to be recorded on the span")
+	}
+
+	// Queries outside of the manager are not traced
+	tracer.Reset()
+	manager.db.Find(&[]models.Policy{})
+	if len(tracer.Spans()) != 0 {
+		t.Errorf("Expected no spans for queries outside the manager, got %d", len(tracer.Spans()))
+	}
+}
+
+func TestSQLiteTracingWrites(t *testing.T) {
+	tracer := NewInMemoryTracer()
+	config := DefaultConfig()
+	config.Tracer = tracer
+	config.DeleteMode = HardDelete
+	manager := newTestManagerWithConfig(t, config)
+	ctx := context.Background()
+
+	// childSpans returns the names of the statement spans of the last manager call
+	childSpans := func(operation string) map[string]bool {
+		t.Helper()
+		spans := tracer.Spans()
+		parent := spans[len(spans)-1]
+		if parent.Name != "ladonsqlmanager."+operation {
+			t.Fatalf("Expected the %s span to end last, got %s", operation, parent.Name)
+		}
+		names := map[string]bool{}
+		for _, span := range spans[:len(spans)-1] {
+			if span.ParentID == parent.ID {
+				names[span.Name] = true
+			}
+		}
+		tracer.Reset()
+		return names
+	}
+
+	policy := &ladon.DefaultPolicy{
+		ID:          "1",
+		Description: "users can read articles",
+		Subjects:    []string{"user"},
+		Effect:      ladon.AllowAccess,
+		Resources:   []string{"article:1"},
+		Actions:     []string{"read"},
+	}
+	createTestPolicies(t, manager, policy)
+	if names := childSpans("Create"); !names["gorm.create"] {
+		t.Errorf("Expected create spans for Create, got %v", names)
+	}
+
+	policy.Description = "users can read all articles"
+	policy.Actions = []string{"list"}
+	if err := manager.Update(ctx, policy); err != nil {
+		t.Fatalf("Update failed: %v", err)
+	}
+	if names := childSpans("Update"); !names["gorm.update"] || !names["gorm.delete"] {
+		t.Errorf("Expected update and delete spans for Update, got %v", names)
+	}
+
+	plan, err := manager.PlanSync(ctx, ladon.Policies{}, true)
+	if err != nil {
+		t.Fatalf("PlanSync failed: %v", err)
+	}
+	tracer.Reset()
+	if err := manager.ApplySync(ctx, plan); err != nil {
+		t.Fatalf("ApplySync failed: %v", err)
+	}
+	if names := childSpans("ApplySync"); !names["gorm.delete"] {
+		t.Errorf("Expected delete spans for ApplySync, got %v", names)
+	}
+}
@@ -0,0 +1,137 @@
+package ladonsqlmanager
+
+import (
+	"context"
+	"time"
+
+	"github.com/ladonsqlmanager/models"
+	"github.com/ory/ladon"
+	"github.com/pkg/errors"
+	"gorm.io/gorm"
+)
+
+// DeletedPolicy is a soft-deleted policy together with the time it was deleted
+type DeletedPolicy struct {
+	Policy    ladon.Policy
+	DeletedAt time.Time
+}
+
+// ListDeleted returns soft-deleted policies, most recently deleted first
+func (s *SQLManager) ListDeleted(ctx context.Context, limit, offset int64) (_ []DeletedPolicy, err error) {
+	ctx, op := s.startOperation(ctx, "ListDeleted")
+	defer func() { op.finish(err) }()
+
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	var policies []models.Policy
+
+	err = s.db.WithContext(ctx).
+		Unscoped().
+		Preload("Subjects").
+		Preload("Actions").
+		Preload("Resources").
+		Where("deleted_at IS NOT NULL").
+		Limit(int(limit)).
+		Offset(int(offset)).
+		Order("deleted_at DESC").
+		Order("id").
+		Find(&policies).Error
+
+	if err != nil {
+		return nil, timeoutError(errors.WithStack(err))
+	}
+
+	op.metrics.Rows = len(policies)
+
+	if err = s.sortPolicyItems(s.db.WithContext(ctx), policies); err != nil {
+		return nil, timeoutError(err)
+	}
+
+	deleted := make([]DeletedPolicy, len(policies))
+	for i, policy := range policies {
+		converted, err := s.convertPolicyToLadon(policy)
+		if err != nil {
+			return nil, err
+		}
+		deleted[i] = DeletedPolicy{
+			Policy:    converted,
+			DeletedAt: policy.DeletedAt.Time,
+		}
+	}
+	return deleted, nil
+}
+
+// Restore undoes the soft delete of a policy. Its relation rows are kept by soft
+// deletes, so the policy comes back exactly as it was deleted.
+func (s *SQLManager) Restore(ctx context.Context, id string) (err error) {
+	ctx, op := s.startOperation(ctx, "Restore")
+	defer func() { op.finish(err) }()
+	op.span.SetAttribute(AttributePolicyID, id)
+
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	var restored int64
+	err = s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		result := tx.Unscoped().
+			Model(&models.Policy{}).
+			Where("id = ? AND deleted_at IS NOT NULL", id).
+			Update("deleted_at", nil)
+
+		if result.Error != nil {
+			return errors.WithStack(result.Error)
+		}
+		if result.RowsAffected == 0 {
+			return errors.WithStack(ErrPolicyNotFound)
+		}
+		restored = result.RowsAffected
+		return s.publishChange(tx, id)
+	})
+
+	if err != nil {
+		return timeoutError(err)
+	}
+	op.metrics.Rows = int(restored)
+	return nil
+}
+
+// Purge permanently removes policies that were soft-deleted more than olderThan
+// ago, together with their relation rows, and returns how many were removed
+func (s *SQLManager) Purge(ctx context.Context, olderThan time.Duration) (_ int64, err error) {
+	ctx, op := s.startOperation(ctx, "Purge")
+	defer func() { op.finish(err) }()
+
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	var purged int64
+	err = s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		var ids []string
+		err := tx.Unscoped().
+			Model(&models.Policy{}).
+			Where("deleted_at IS NOT NULL AND deleted_at < ?", time.Now().Add(-olderThan)).
+			Pluck("id", &ids).Error
+		if err != nil {
+			return errors.WithStack(err)
+		}
+
+		for _, id := range ids {
+			removed, err := s.purge(id, tx)
+			if err != nil {
+				return err
+			}
+			if err := s.publishChange(tx, id); err != nil {
+				return err
+			}
+			purged += removed
+		}
+		return nil
+	})
+
+	if err != nil {
+		return 0, timeoutError(err)
+	}
+	op.metrics.Rows = int(purged)
+	return purged, nil
+}